@@ -0,0 +1,372 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/validator"
+)
+
+// readUserParam loads the user named by the :id parameter of an admin route.
+// It writes the error response itself and returns nil when there is none.
+func (app *application) readUserParam(w http.ResponseWriter, r *http.Request) *data.User {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return nil
+	}
+
+	user, err := app.models.UserModel.GetById(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil
+	}
+
+	return user
+}
+
+// revokeUserCredentials signs a user out everywhere: every token, whatever
+// its scope, and every API key.
+func (app *application) revokeUserCredentials(userID int64) error {
+	err := app.models.TokenModel.DeleteAllForUser(userID)
+	if err != nil {
+		return err
+	}
+
+	return app.models.APIKeyModel.DeleteAllForUser(userID)
+}
+
+func (app *application) listUsersHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Search string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Search = app.readString(qs, "search", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "email", "created_at", "-id", "-name", "-email", "-created_at"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	users, err := app.models.UserModel.GetAll(input.Search, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"users": users}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// writeUserPermissions responds with the roles of a user and every
+// permission they hold, directly or through those roles.
+func (app *application) writeUserPermissions(w http.ResponseWriter, r *http.Request, userID int64) {
+	permissions, err := app.models.PermissionModel.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	roles, err := app.models.RoleModel.GetAllForUser(userID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if permissions == nil {
+		permissions = data.Permissions{}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"roles": roles, "permissions": permissions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+
+	app.writeUserPermissions(w, r, user.ID)
+}
+
+// readPermissionsInput reads the permission codes and role names to grant or
+// revoke, checking that each of them exists.
+func (app *application) readPermissionsInput(w http.ResponseWriter, r *http.Request) (permissions []string, roles []string, ok bool) {
+	var input struct {
+		Permissions []string `json:"permissions"`
+		Roles       []string `json:"roles"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	knownPermissions, err := app.models.PermissionModel.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	knownRoles, err := app.models.RoleModel.GetAll()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return nil, nil, false
+	}
+
+	roleNames := make([]string, len(knownRoles))
+	for i, role := range knownRoles {
+		roleNames[i] = role.Name
+	}
+
+	v := validator.New()
+
+	v.Check(len(input.Permissions) == 0 && len(input.Roles) == 0, "permissions", "must contain at least one permission or role")
+	v.Check(!validator.Unique(input.Permissions), "permissions", "cannot contain duplicate values")
+	v.Check(!validator.Unique(input.Roles), "roles", "cannot contain duplicate values")
+
+	for _, code := range input.Permissions {
+		v.Check(!knownPermissions.Include(code), "permissions", "unknown permission "+code)
+	}
+
+	for _, role := range input.Roles {
+		v.Check(!validator.PermittedValue(role, roleNames...), "roles", "unknown role "+role)
+	}
+
+	if !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return nil, nil, false
+	}
+
+	return input.Permissions, input.Roles, true
+}
+
+func (app *application) grantUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+
+	permissions, roles, ok := app.readPermissionsInput(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.PermissionModel.AddForUsers(user.ID, permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.RoleModel.AddForUsers(user.ID, roles...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.permissions.invalidate(user.ID)
+
+	app.writeUserPermissions(w, r, user.ID)
+}
+
+// revokeUserPermissionsHandler only takes away permissions granted directly;
+// a permission the user also holds through a role stays until the role is
+// revoked as well.
+func (app *application) revokeUserPermissionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+
+	permissions, roles, ok := app.readPermissionsInput(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.models.PermissionModel.RemoveForUsers(user.ID, permissions...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.RoleModel.RemoveForUsers(user.ID, roles...)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.permissions.invalidate(user.ID)
+
+	app.writeUserPermissions(w, r, user.ID)
+}
+
+func (app *application) forceActivateUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+
+	user.Activated = true
+
+	err := app.models.UserModel.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.TokenModel.DeleteAllForUsers(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// suspendUserHandler suspends or reinstates an account. A suspended user is
+// signed out and cannot log in or use an API key until reinstated.
+func (app *application) suspendUserHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Suspended *bool `json:"suspended"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+
+	v := validator.New()
+
+	v.Check(input.Suspended == nil, "suspended", "must be provided")
+	v.Check(user.ID == app.getUserContext(r).ID, "suspended", "cannot be changed for your own account")
+
+	if !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	user.Suspended = *input.Suspended
+
+	err = app.models.UserModel.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Suspended {
+		err = app.revokeUserCredentials(user.ID)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) revokeUserTokensHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.readUserParam(w, r)
+	if user == nil {
+		return
+	}
+
+	err := app.revokeUserCredentials(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "all tokens and api keys of the user have been revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	if id == app.getUserContext(r).ID {
+		v := validator.New()
+		v.Add("id", "cannot delete your own account")
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	err = app.models.UserModel.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.permissions.invalidate(id)
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "user successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
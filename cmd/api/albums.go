@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/validator"
+)
+
+func (app *application) showAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	album, err := app.models.AlbumModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	songs, err := app.models.AlbumModel.GetSongs(album.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"album": album, "songs": songs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listAlbumsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title  string
+		Artist string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Title = app.readString(qs, "title", "")
+	input.Artist = app.readString(qs, "artist", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "title", "artist", "year", "-id", "-title", "-artist", "-year"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	albums, err := app.models.AlbumModel.GetAll(input.Title, input.Artist, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"albums": albums}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Title     string `json:"title"`
+		Artist    string `json:"artist"`
+		Year      int    `json:"year"`
+		Thumbnail string `json:"thumbnail"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	album := &data.Album{
+		Title:     input.Title,
+		Artist:    input.Artist,
+		Year:      input.Year,
+		Thumbnail: input.Thumbnail,
+	}
+
+	v := validator.New()
+
+	if data.ValidateAlbum(v, album); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	err = app.models.AlbumModel.Insert(album)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateAlbum):
+			v.Add("title", "an album with this title already exists for this artist")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/albums/%d", album.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"album": album}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	album, err := app.models.AlbumModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Title     *string `json:"title"`
+		Artist    *string `json:"artist"`
+		Year      *int    `json:"year"`
+		Thumbnail *string `json:"thumbnail"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Title != nil {
+		album.Title = *input.Title
+	}
+
+	if input.Artist != nil {
+		album.Artist = *input.Artist
+	}
+
+	if input.Year != nil {
+		album.Year = *input.Year
+	}
+
+	if input.Thumbnail != nil {
+		album.Thumbnail = *input.Thumbnail
+	}
+
+	v := validator.New()
+
+	if data.ValidateAlbum(v, album); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	err = app.models.AlbumModel.Update(album)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateAlbum):
+			v.Add("title", "an album with this title already exists for this artist")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"album": album}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteAlbumHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.AlbumModel.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "album successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,435 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/validator"
+)
+
+func (app *application) showArtistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	artist, err := app.models.ArtistModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"artist": artist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listArtistsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "-id", "-name"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	artists, err := app.models.ArtistModel.GetAll(input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"artists": artists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showArtistSongsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Role string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Role = app.readString(qs, "role", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = "id"
+	input.Filters.SortSafelist = []string{"id"}
+
+	if input.Role != "" {
+		data.ValidateArtistRole(v, input.Role)
+	}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	artist, err := app.models.ArtistModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	songs, err := app.models.ArtistModel.GetSongs(artist.ID, input.Role, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"artist": artist, "songs": songs}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) createArtistHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string `json:"name"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	artist := &data.Artist{Name: input.Name}
+
+	v := validator.New()
+
+	if data.ValidateArtist(v, artist); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	err = app.models.ArtistModel.Insert(artist)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateArtist):
+			v.Add("name", "an artist with this name already exists")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/artists/%d", artist.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"artist": artist}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) updateArtistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	artist, err := app.models.ArtistModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var input struct {
+		Name *string `json:"name"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Name != nil {
+		artist.Name = *input.Name
+	}
+
+	v := validator.New()
+
+	if data.ValidateArtist(v, artist); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	err = app.models.ArtistModel.Update(artist)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		case errors.Is(err, data.ErrDuplicateArtist):
+			v.Add("name", "an artist with this name already exists")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"artist": artist}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteArtistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	err = app.models.ArtistModel.Delete(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "artist successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSongCreditsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	_, err = app.models.SongModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	credits, err := app.models.ArtistModel.GetCredits(id)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"artists": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// addSongCreditHandler credits an artist on a song. The artist is given
+// either by id or by name, in which case it is created when unknown.
+func (app *application) addSongCreditHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		ArtistID int64  `json:"artist_id"`
+		Name     string `json:"name"`
+		Role     string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = "primary"
+	}
+
+	v := validator.New()
+
+	data.ValidateArtistRole(v, input.Role)
+	v.Check(input.ArtistID == 0 && input.Name == "", "artist_id", "an artist id or name must be provided")
+	v.Check(input.ArtistID != 0 && input.Name != "", "artist_id", "only one of artist id or name can be provided")
+	if input.Name != "" {
+		data.ValidateArtist(v, &data.Artist{Name: input.Name})
+	}
+
+	if !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	song, err := app.models.SongModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	var artist *data.Artist
+	if input.Name != "" {
+		artist, err = app.models.ArtistModel.GetOrInsert(input.Name)
+	} else {
+		artist, err = app.models.ArtistModel.Get(input.ArtistID)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.Add("artist_id", "artist does not exist")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.ArtistModel.AddCredit(song.ID, artist.ID, input.Role)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	credits, err := app.models.ArtistModel.GetCredits(song.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"artists": credits}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removeSongCreditHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	artistID, err := app.readParamID(r, "artist_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	role := app.readString(r.URL.Query(), "role", "")
+
+	v := validator.New()
+
+	if role != "" {
+		if data.ValidateArtistRole(v, role); !v.Valid() {
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+			return
+		}
+	}
+
+	err = app.models.ArtistModel.RemoveCredit(id, artistID, role)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "artist credit successfully removed"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// creditPrimaryArtist links a newly created song to the artist named in its
+// artist field.
+func (app *application) creditPrimaryArtist(song *data.Song) error {
+	artist, err := app.models.ArtistModel.GetOrInsert(song.Artist)
+	if err != nil {
+		return err
+	}
+
+	return app.models.ArtistModel.AddCredit(song.ID, artist.ID, "primary")
+}
+
+// creditNewSong credits a song that was just stored to its primary artist.
+// The song is already stored, so a failure is only logged: a missing credit
+// can be added later.
+func (app *application) creditNewSong(r *http.Request, song *data.Song) {
+	err := app.creditPrimaryArtist(song)
+	if err != nil {
+		app.logErrors(r, err)
+	}
+}
+
+// replacePrimaryArtist moves the primary credit of a song to the artist now
+// named in its artist field.
+func (app *application) replacePrimaryArtist(song *data.Song) error {
+	artist, err := app.models.ArtistModel.GetOrInsert(song.Artist)
+	if err != nil {
+		return err
+	}
+
+	return app.models.ArtistModel.ReplacePrimaryCredit(song.ID, artist.ID)
+}
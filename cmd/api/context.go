@@ -9,7 +9,11 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey   = contextKey("user")
+	tokenContextKey  = contextKey("token")
+	apiKeyContextKey = contextKey("api_key")
+)
 
 func (app *application) setUserContext(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -24,3 +28,29 @@ func (app *application) getUserContext(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) setTokenContext(r *http.Request, token *data.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+
+	return r.WithContext(ctx)
+}
+
+// getTokenContext returns the token the request was authenticated with, or
+// nil for anonymous requests.
+func (app *application) getTokenContext(r *http.Request) *data.Token {
+	token, _ := r.Context().Value(tokenContextKey).(*data.Token)
+	return token
+}
+
+func (app *application) setAPIKeyContext(r *http.Request, key *data.APIKey) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyContextKey, key)
+
+	return r.WithContext(ctx)
+}
+
+// getAPIKeyContext returns the API key the request was authenticated with, or
+// nil when it was not made with one.
+func (app *application) getAPIKeyContext(r *http.Request) *data.APIKey {
+	key, _ := r.Context().Value(apiKeyContextKey).(*data.APIKey)
+	return key
+}
@@ -5,16 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"net/url"
-	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/Arkitecth/apollo/validator"
-	"github.com/aws/aws-sdk-go-v2/aws"
-	aws_config "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -107,39 +105,13 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 	return nil
 }
 
-func (app *application) uploadS3(r *http.Request, key string) (string, error) {
-	r.ParseMultipartForm(10 << 20)
-
-	file, handler, err := r.FormFile(key)
-	if err != nil {
-		return "", err
-	}
-	dstCopy, err := os.Create(handler.Filename)
-	if err != nil {
-		return "", err
-	}
-
-	io.Copy(dstCopy, file)
-
-	cfg, err := aws_config.LoadDefaultConfig(r.Context())
-	if err != nil {
-		return "", err
-	}
-
-	client := s3.NewFromConfig(cfg)
-	bucketName := "apollomusicplayer"
-	_, err = client.PutObject(r.Context(), &s3.PutObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(handler.Filename),
-		Body:   file,
-	})
+func (app *application) readFile(r *http.Request, key string) (multipart.File, *multipart.FileHeader, error) {
+	err := r.ParseMultipartForm(10 << 20)
 	if err != nil {
-		return "", err
+		return nil, nil, err
 	}
-	url := fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucketName, cfg.Region, handler.Filename)
-
-	return url, nil
 
+	return r.FormFile(key)
 }
 
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
@@ -152,6 +124,16 @@ func (app *application) readString(qs url.Values, key string, defaultValue strin
 	return s
 }
 
+func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
+	csv := qs.Get(key)
+
+	if csv == "" {
+		return defaultValue
+	}
+
+	return strings.Split(csv, ",")
+}
+
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
 	s := qs.Get(key)
 
@@ -167,16 +149,68 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+// readExpectedVersion returns the record version a client expects to modify,
+// taken from an If-Match ETag or an X-Expected-Version header. Zero means the
+// client sent neither.
+func (app *application) readExpectedVersion(r *http.Request) (int, error) {
+	value := r.Header.Get("X-Expected-Version")
+	if value == "" {
+		value = strings.TrimPrefix(r.Header.Get("If-Match"), "W/")
+		if value == "" || value == "*" {
+			return 0, nil
+		}
+
+		unquoted, err := strconv.Unquote(value)
+		if err != nil {
+			return 0, errors.New("If-Match header must be a quoted version")
+		}
+		value = unquoted
+	}
+
+	version, err := strconv.Atoi(value)
+	if err != nil || version < 1 {
+		return 0, errors.New("expected version must be a positive integer")
+	}
+
+	return version, nil
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
 	go func() {
 		defer app.wg.Done()
-		defer func() {
-			if err := recover(); err != nil {
-				app.logger.Error(fmt.Sprintf("%v", err))
+		app.runTask(fn)
+	}()
+
+}
+
+// periodically runs fn every interval in the background until the server
+// starts shutting down. A run in progress is waited for like any other
+// background task, and a panic only ends that run.
+func (app *application) periodically(interval time.Duration, fn func()) {
+	app.wg.Add(1)
+	go func() {
+		defer app.wg.Done()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				app.runTask(fn)
+			case <-app.shutdown:
+				return
 			}
-		}()
-		fn()
+		}
 	}()
+}
 
+func (app *application) runTask(fn func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.logger.Error(fmt.Sprintf("%v", err))
+		}
+	}()
+	fn()
 }
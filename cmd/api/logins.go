@@ -0,0 +1,89 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// loginThrottle backs off clients that keep sending wrong passwords, whatever
+// accounts they try. After maxAttempts failures an IP is blocked for
+// duration, doubling with every further failure up to maxDuration. Failures
+// are forgotten once an IP stops failing for maxDuration.
+type loginThrottle struct {
+	mu          sync.Mutex
+	maxAttempts int
+	duration    time.Duration
+	maxDuration time.Duration
+	clients     map[string]*loginClient
+}
+
+type loginClient struct {
+	failures     int
+	blockedUntil time.Time
+	lastFailure  time.Time
+}
+
+func newLoginThrottle(maxAttempts int, duration time.Duration, maxDuration time.Duration) *loginThrottle {
+	return &loginThrottle{
+		maxAttempts: maxAttempts,
+		duration:    duration,
+		maxDuration: maxDuration,
+		clients:     make(map[string]*loginClient),
+	}
+}
+
+// blocked reports how long ip has to wait before it may try again.
+func (t *loginThrottle) blocked(ip string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	client, ok := t.clients[ip]
+	if !ok {
+		return 0, false
+	}
+
+	wait := time.Until(client.blockedUntil)
+
+	return wait, wait > 0
+}
+
+func (t *loginThrottle) fail(ip string) {
+	if t.maxAttempts <= 0 {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	client, ok := t.clients[ip]
+	if !ok {
+		client = &loginClient{}
+		t.clients[ip] = client
+	}
+
+	client.failures++
+	client.lastFailure = time.Now()
+
+	if client.failures >= t.maxAttempts {
+		client.blockedUntil = client.lastFailure.Add(backoff(t.duration, t.maxDuration, client.failures-t.maxAttempts))
+	}
+}
+
+func (t *loginThrottle) sweep() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for ip, client := range t.clients {
+		if time.Since(client.lastFailure) > t.maxDuration && time.Now().After(client.blockedUntil) {
+			delete(t.clients, ip)
+		}
+	}
+}
+
+// backoff doubles duration for every step, up to max.
+func backoff(duration time.Duration, max time.Duration, steps int) time.Duration {
+	for i := 0; i < steps && duration < max; i++ {
+		duration *= 2
+	}
+	return min(duration, max)
+}
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/internal/metadata"
+	"github.com/Arkitecth/apollo/internal/storage"
+	"github.com/Arkitecth/apollo/validator"
+)
+
+// mediaReleaseGrace is how long a media object nobody uses is kept before its
+// blobs are removed.
+const mediaReleaseGrace = time.Hour
+
+// songFromMetadata builds an unsaved song from the tags of an uploaded file,
+// falling back to the file name when the file carries no title.
+func (app *application) songFromMetadata(md *metadata.Metadata, filename string) *data.Song {
+	song := &data.Song{
+		Name:        md.Title,
+		Artist:      md.Artist,
+		TrackNumber: md.Track,
+		Year:        md.Year,
+		Duration:    int(md.Duration.Round(time.Second) / time.Second),
+		Genres:      data.NormalizeGenres([]string{md.Genre}),
+	}
+
+	if song.Name == "" {
+		song.Name = strings.TrimSuffix(path.Base(filename), path.Ext(filename))
+	}
+
+	return song
+}
+
+// applySongOverrides lets explicit request fields win over embedded tags so
+// untagged files can still be uploaded in one request.
+func (app *application) applySongOverrides(song *data.Song, name, artist string, genres []string) {
+	if name != "" {
+		song.Name = name
+	}
+	if artist != "" {
+		song.Artist = artist
+	}
+	if genres != nil {
+		song.Genres = data.NormalizeGenres(genres)
+	}
+}
+
+// songAlbum returns the album an uploaded song belongs to, or nil when neither
+// the request nor the tags name one.
+func (app *application) songAlbum(song *data.Song, title string, md *metadata.Metadata) *data.Album {
+	if title == "" {
+		title = md.Album
+	}
+	if title == "" {
+		return nil
+	}
+
+	return &data.Album{
+		Title:     title,
+		Artist:    song.Artist,
+		Year:      song.Year,
+		Thumbnail: song.Thumbnail,
+	}
+}
+
+func validateSongAlbum(v *validator.Validator, album *data.Album) {
+	if album == nil {
+		return
+	}
+
+	v.Check(len(album.Title) > 200, "album", "album cannot be greater than 200 bytes")
+}
+
+// setSongAlbum links the song to its album, creating the album the first
+// time one of its tracks is uploaded.
+func (app *application) setSongAlbum(song *data.Song, album *data.Album) error {
+	if album == nil {
+		return nil
+	}
+
+	err := app.models.AlbumModel.GetOrInsert(album)
+	if err != nil {
+		return err
+	}
+
+	song.AlbumID = album.ID
+	return nil
+}
+
+func contentHash(r io.Reader) (string, error) {
+	hash := sha256.New()
+
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// audioExtensions are the extensions an upload keeps in its storage key.
+var audioExtensions = []string{".mp3", ".flac", ".ogg", ".oga", ".m4a", ".mp4"}
+
+// audioKey is the content addressed storage key of an upload. The extension
+// is kept so backends without stored content types can still infer one, as
+// long as it is one of audioExtensions.
+func audioKey(hash string, filename string) string {
+	ext := strings.ToLower(path.Ext(filename))
+	if !validator.PermittedValue(ext, audioExtensions...) {
+		ext = ""
+	}
+
+	return "audio/" + hash + ext
+}
+
+func coverKey(hash string, picture *metadata.Picture) string {
+	return "thumbnails/" + hash + picture.Extension()
+}
+
+// mediaClaim is the media object a request is creating a song for: either
+// one already stored, which the request holds a reference on, or a new one
+// whose blobs the request writes itself.
+type mediaClaim struct {
+	object  *data.MediaObject
+	written bool
+	used    bool
+}
+
+// acquireMediaObject takes a reference on the media object already stored
+// for hash, if any, so its blobs cannot be swept before the song using it is
+// stored.
+func (app *application) acquireMediaObject(hash string) (*mediaClaim, error) {
+	object, err := app.models.MediaObjectModel.Acquire(hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			return &mediaClaim{}, nil
+		default:
+			return nil, err
+		}
+	}
+
+	return &mediaClaim{object: object}, nil
+}
+
+// settleMediaClaim is deferred by every handler that acquired a media object.
+// Unless a song ended up using the object it gives back the reference, or
+// registers the blobs the request wrote as unused so sweepMediaObjects
+// removes them. They are not deleted outright: a concurrent upload of the
+// same file stores it under the same key.
+func (app *application) settleMediaClaim(claim *mediaClaim) {
+	if claim.used || claim.object == nil {
+		return
+	}
+
+	var err error
+
+	switch {
+	case claim.object.ID != 0:
+		err = app.models.MediaObjectModel.Release(claim.object.ID)
+	case claim.written:
+		err = app.models.MediaObjectModel.InsertReleased(claim.object)
+	}
+
+	if err != nil {
+		app.logger.Error(err.Error(), "media_object", claim.object.Hash)
+	}
+}
+
+func (app *application) newMediaObject(hash string, key string, size int64, md *metadata.Metadata) *data.MediaObject {
+	object := &data.MediaObject{
+		Hash:        hash,
+		Key:         key,
+		Size:        size,
+		ContentType: md.ContentType(),
+	}
+
+	if md.Picture != nil {
+		object.CoverKey = coverKey(hash, md.Picture)
+	}
+
+	return object
+}
+
+// setSongMedia points the song at the stored audio and cover art.
+func (app *application) setSongMedia(song *data.Song, object *data.MediaObject) {
+	song.SongURL = app.storage.URL(object.Key)
+	song.Thumbnail = ""
+	if object.CoverKey != "" {
+		song.Thumbnail = app.storage.URL(object.CoverKey)
+	}
+}
+
+func (app *application) storeCoverArt(ctx context.Context, object *data.MediaObject, picture *metadata.Picture) error {
+	if picture == nil || object.CoverKey == "" {
+		return nil
+	}
+
+	return app.storage.Put(ctx, object.CoverKey, bytes.NewReader(picture.Data), int64(len(picture.Data)), picture.MIMEType)
+}
+
+// removeMediaBlobs deletes the stored audio and cover art of a media object.
+func (app *application) removeMediaBlobs(ctx context.Context, object *data.MediaObject) error {
+	for _, key := range []string{object.Key, object.CoverKey} {
+		if key == "" {
+			continue
+		}
+
+		err := app.storage.Delete(ctx, key)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sweepMediaObjects removes media objects no song has used for
+// mediaReleaseGrace. The grace period outlasts an upload that stores the
+// same file under the same key while the object is unused, so the blob is
+// not removed from under it.
+func (app *application) sweepMediaObjects() {
+	count := 0
+
+	for range 100 {
+		object, err := app.models.MediaObjectModel.DeleteReleased(time.Now().Add(-mediaReleaseGrace), func(object *data.MediaObject) error {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+			defer cancel()
+
+			return app.removeMediaBlobs(ctx, object)
+		})
+		if err != nil {
+			app.logger.Error(err.Error())
+			break
+		}
+		if object == nil {
+			break
+		}
+		count++
+	}
+
+	if count > 0 {
+		app.logger.Info("removed unused media objects", "count", count)
+	}
+}
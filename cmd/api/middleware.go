@@ -89,6 +89,11 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 
 		headerParts := strings.Split(header, " ")
 
+		if len(headerParts) == 2 && headerParts[0] == "ApiKey" {
+			app.authenticateAPIKey(w, r, headerParts[1], next)
+			return
+		}
+
 		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
@@ -114,12 +119,53 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
+		if user.Suspended {
+			app.suspendedAccountResponse(w, r)
+			return
+		}
+
+		hash := data.HashPlaintext(token)
+		app.sessionUsage.touch(hash)
+
 		r = app.setUserContext(r, user)
+		r = app.setTokenContext(r, &data.Token{UserID: user.ID, Hash: hash, Scope: data.ScopeAuthentication})
 
 		next.ServeHTTP(w, r)
 	})
 }
 
+// authenticateAPIKey serves a request made with an API key instead of a
+// bearer token.
+func (app *application) authenticateAPIKey(w http.ResponseWriter, r *http.Request, plaintext string, next http.Handler) {
+	if !validator.Matches(plaintext, data.APIKeyRX) {
+		app.invalidAuthenticationTokenResponse(w, r)
+		return
+	}
+
+	user, key, err := app.models.APIKeyModel.GetUserFromKey(plaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.invalidAuthenticationTokenResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	app.apiKeyUsage.touch(key.Hash)
+
+	r = app.setUserContext(r, user)
+	r = app.setAPIKeyContext(r, key)
+
+	next.ServeHTTP(w, r)
+}
+
 func (app *application) requireAuthenticatedUser(next http.HandlerFunc) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		user := app.getUserContext(r)
@@ -151,7 +197,7 @@ func (app *application) requireAuthorizedUser(code string, next http.HandlerFunc
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.getUserContext(r)
 
-		permissions, err := app.models.PermissionModel.GetAllForUser(user.ID)
+		permissions, err := app.userPermissions(user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -162,6 +208,11 @@ func (app *application) requireAuthorizedUser(code string, next http.HandlerFunc
 			return
 		}
 
+		if key := app.getAPIKeyContext(r); key != nil && !key.Allows(code) {
+			app.notAuthorizedResponse(w, r)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	}
 
@@ -169,6 +220,21 @@ func (app *application) requireAuthorizedUser(code string, next http.HandlerFunc
 
 }
 
+// requireSessionUser keeps API keys away from routes that manage credentials,
+// so a leaked key cannot be used to mint more.
+func (app *application) requireSessionUser(next http.HandlerFunc) http.HandlerFunc {
+	fn := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.getAPIKeyContext(r) != nil {
+			app.notAuthorizedResponse(w, r)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+
+	return app.requireActivatedUser(fn)
+}
+
 func (app *application) enableCORS(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Vary", "Origin")
@@ -181,10 +247,11 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 			for i := range len(app.config.cors.trustedOrigins) {
 				if origin == app.config.cors.trustedOrigins[i] {
 					w.Header().Set("Access-Control-Allow-Origin", origin)
+					w.Header().Set("Access-Control-Expose-Headers", "Location, ETag, Upload-Offset, Upload-Length, Upload-Expires")
 
 					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
 						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PATCH, PUT, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, If-Match, X-Expected-Version, Upload-Offset")
 
 						w.WriteHeader(http.StatusOK)
 						return
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/internal/oidc"
+	"github.com/Arkitecth/apollo/validator"
+)
+
+const (
+	oidcLoginTTL = 10 * time.Minute
+
+	oidcStateCookie  = "apollo_oidc_state"
+	oidcCallbackPath = "/v1/oidc/callback"
+)
+
+// oidcStateHash is the cookie value that ties a login state to the browser
+// that started the login.
+func oidcStateHash(state string) string {
+	return base64.RawURLEncoding.EncodeToString(data.HashPlaintext(state))
+}
+
+// setOIDCStateCookie binds a login to the browser it was started in, so a
+// callback URL carrying someone else's state and code cannot be used to log
+// the victim in to the attacker's account. Lax is the strictest SameSite
+// mode that is still sent on the provider's redirect back.
+func (app *application) setOIDCStateCookie(w http.ResponseWriter, value string, maxAge time.Duration) {
+	cookie := &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value,
+		Path:     oidcCallbackPath,
+		MaxAge:   int(maxAge / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.config.baseURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	}
+	if maxAge <= 0 {
+		cookie.MaxAge = -1
+	}
+
+	http.SetCookie(w, cookie)
+}
+
+// oidcLoginHandler sends the user to the identity provider. The state, nonce
+// and PKCE verifier are kept in the database until the provider redirects
+// back to oidcCallbackHandler, and a hash of the state is kept in a cookie.
+func (app *application) oidcLoginHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var values [3]string
+
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		values[i] = value
+	}
+
+	state := values[0]
+	login := &data.LoginState{Nonce: values[1], CodeVerifier: values[2]}
+
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), state, login.Nonce, oidc.Challenge(login.CodeVerifier))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.IdentityModel.InsertLoginState(state, login, oidcLoginTTL)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setOIDCStateCookie(w, oidcStateHash(state), oidcLoginTTL)
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// oidcCallbackHandler finishes a login at the identity provider and logs the
+// user in the same way a password login does.
+func (app *application) oidcCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	qs := r.URL.Query()
+
+	v := validator.New()
+
+	if providerError := qs.Get("error"); providerError != "" {
+		v.Add("login", "the identity provider returned "+providerError)
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	state := app.readString(qs, "state", "")
+	code := app.readString(qs, "code", "")
+
+	v.Check(state == "", "state", "must be provided")
+	v.Check(code == "", "code", "must be provided")
+
+	if !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil || subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(oidcStateHash(state))) != 1 {
+		v.Add("state", "login was not started in this browser")
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	app.setOIDCStateCookie(w, "", 0)
+
+	login, err := app.models.IdentityModel.ConsumeLoginState(state)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.Add("state", "invalid or expired login state")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), code, login.CodeVerifier, login.Nonce)
+	if err != nil {
+		switch {
+		case errors.Is(err, oidc.ErrExchange), errors.Is(err, oidc.ErrInvalidToken), errors.Is(err, oidc.ErrUnknownSigner):
+			app.logger.Warn("oidc login rejected", "error", err.Error())
+			app.invalidCredentialsResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user, err := app.models.IdentityModel.GetUser(claims.Issuer, claims.Subject)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if user == nil {
+		if v.Check(claims.Email == "" || !claims.EmailVerified, "email", "must be verified by the identity provider"); !v.Valid() {
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+			return
+		}
+
+		user, err = app.models.UserModel.GetByEmail(claims.Email)
+		if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if user == nil {
+			if v.Check(!app.config.oidc.autoProvision, "email", "no account exists for this email address"); !v.Valid() {
+				app.failedInvalidationResponse(w, r, v.ErrorMap)
+				return
+			}
+
+			user, err = app.provisionOIDCUser(claims)
+			if err != nil {
+				app.serverErrorResponse(w, r, err)
+				return
+			}
+		} else if !user.Activated {
+			err = app.claimUnactivatedUser(user)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrEditConflict):
+					app.editConflictResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+		}
+
+		identity := &data.Identity{
+			UserID:  user.ID,
+			Issuer:  claims.Issuer,
+			Subject: claims.Subject,
+			Email:   claims.Email,
+		}
+
+		err = app.models.IdentityModel.Link(identity)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	app.startSession(w, r, user)
+}
+
+// provisionOIDCUser creates an activated account for a verified identity
+// provider email. It gets a random password nobody knows; the user can set
+// one through the password reset flow.
+func (app *application) provisionOIDCUser(claims *oidc.Claims) (*data.User, error) {
+	name := strings.TrimSpace(claims.Name)
+	if name == "" {
+		name, _, _ = strings.Cut(claims.Email, "@")
+	}
+	if len(name) >= 500 {
+		name = name[:499]
+	}
+
+	user := &data.User{
+		Name:      name,
+		Email:     claims.Email,
+		Activated: true,
+	}
+
+	err := setRandomPassword(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.UserModel.Insert(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = app.models.RoleModel.AddForUsers(user.ID, data.RoleListener)
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// claimUnactivatedUser hands an account that was registered but never
+// activated to the verified owner of its email. Anyone could have registered
+// it, so the password they chose is replaced and anything they signed in
+// with is revoked before the identity is linked.
+func (app *application) claimUnactivatedUser(user *data.User) error {
+	err := setRandomPassword(user)
+	if err != nil {
+		return err
+	}
+
+	user.Activated = true
+
+	err = app.models.UserModel.Update(user)
+	if err != nil {
+		return err
+	}
+
+	return app.revokeUserCredentials(user.ID)
+}
+
+func setRandomPassword(user *data.User) error {
+	password, err := oidc.RandomString()
+	if err != nil {
+		return err
+	}
+
+	return user.Password.Set(password)
+}
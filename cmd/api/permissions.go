@@ -0,0 +1,142 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/Arkitecth/apollo/internal/data"
+)
+
+// permissionCache keeps the permissions of recently seen users so that
+// requireAuthorizedUser does not query them on every request. It holds at
+// most size users, dropping the least recently used, and an entry is only
+// trusted for ttl: grants made by another instance of the API show up after
+// at most that long. Grants made through this instance invalidate the entry
+// straight away.
+type permissionCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	size    int
+	entries map[int64]*list.Element
+	order   *list.List
+
+	// generation changes on every invalidation, so a load that raced with
+	// one does not put stale permissions back.
+	generation uint64
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+type permissionCacheEntry struct {
+	userID      int64
+	permissions data.Permissions
+	expiry      time.Time
+}
+
+func newPermissionCache(size int, ttl time.Duration) *permissionCache {
+	return &permissionCache{
+		ttl:     ttl,
+		size:    size,
+		entries: make(map[int64]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// get returns the cached permissions of a user. On a miss it returns the
+// generation to pass to set with the permissions loaded instead.
+func (c *permissionCache) get(userID int64) (data.Permissions, uint64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[userID]; ok {
+		entry := element.Value.(*permissionCacheEntry)
+
+		if time.Now().Before(entry.expiry) {
+			c.order.MoveToFront(element)
+			c.hits++
+			return entry.permissions, c.generation, true
+		}
+
+		c.remove(element)
+	}
+
+	c.misses++
+
+	return nil, c.generation, false
+}
+
+func (c *permissionCache) set(userID int64, permissions data.Permissions, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.size <= 0 || c.ttl <= 0 || generation != c.generation {
+		return
+	}
+
+	if element, ok := c.entries[userID]; ok {
+		c.remove(element)
+	}
+
+	element := c.order.PushFront(&permissionCacheEntry{
+		userID:      userID,
+		permissions: permissions,
+		expiry:      time.Now().Add(c.ttl),
+	})
+	c.entries[userID] = element
+
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+		c.evictions++
+	}
+}
+
+// invalidate forgets the permissions of a user after their grants changed.
+func (c *permissionCache) invalidate(userID int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+
+	if element, ok := c.entries[userID]; ok {
+		c.remove(element)
+	}
+}
+
+func (c *permissionCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*permissionCacheEntry).userID)
+}
+
+// stats is published through expvar.
+func (c *permissionCache) stats() map[string]int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return map[string]int64{
+		"hits":      c.hits,
+		"misses":    c.misses,
+		"evictions": c.evictions,
+		"size":      int64(c.order.Len()),
+	}
+}
+
+// userPermissions returns every permission a user holds, from the cache when
+// it can.
+func (app *application) userPermissions(userID int64) (data.Permissions, error) {
+	permissions, generation, ok := app.permissions.get(userID)
+	if ok {
+		return permissions, nil
+	}
+
+	permissions, err := app.models.PermissionModel.GetAllForUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	app.permissions.set(userID, permissions, generation)
+
+	return permissions, nil
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/Arkitecth/apollo/internal/data"
@@ -8,23 +9,72 @@ import (
 	"net/http"
 )
 
-func (app *application) showPlaylistHandler(w http.ResponseWriter, r *http.Request) {
-	id, err := app.readIDParam(r)
-	if err != nil {
-		app.notFoundResponse(w, r)
-		return
-	}
-	playlist, err := app.models.PlaylistModel.Get(id)
+// readPlaylist loads a playlist the authenticated user needs at least role on.
+// Playlists the user cannot see get a not found response, playlists they can
+// see but not act on with role get a forbidden response.
+func (app *application) readPlaylist(w http.ResponseWriter, r *http.Request, id int64, role string) (*data.Playlist, bool) {
+	user := app.getUserContext(r)
+
+	playlist, err := app.models.PlaylistModel.Get(id, user.ID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
 			app.notFoundResponse(w, r)
-			return
-
 		default:
 			app.serverErrorResponse(w, r, err)
-			return
 		}
+		return nil, false
+	}
+
+	if !playlist.Allows(role) {
+		app.notAuthorizedResponse(w, r)
+		return nil, false
+	}
+
+	return playlist, true
+}
+
+// checkSmartRules normalizes and validates the rules of a smart playlist. The
+// playlists its rules refer to must be regular playlists the owner can see.
+func (app *application) checkSmartRules(v *validator.Validator, playlist *data.Playlist) error {
+	playlist.Rules.Normalize()
+
+	if data.ValidateSmartRules(v, playlist.Rules); !v.Valid() {
+		return nil
+	}
+
+	for _, id := range playlist.Rules.InPlaylists() {
+		if id == playlist.ID {
+			v.Add("rules.rules", "cannot refer to the playlist itself")
+			continue
+		}
+
+		other, err := app.models.PlaylistModel.Get(id, playlist.UserID)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				v.Add("rules.rules", fmt.Sprintf("playlist %d does not exist", id))
+				continue
+			default:
+				return err
+			}
+		}
+
+		v.Check(other.Rules != nil, "rules.rules", fmt.Sprintf("playlist %d is a smart playlist", id))
+	}
+
+	return nil
+}
+
+func (app *application) showPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+	playlist, ok := app.readPlaylist(w, r, id, data.PlaylistViewer)
+	if !ok {
+		return
 	}
 
 	err = app.writeJSON(w, http.StatusOK, envelope{"playlist": playlist}, nil)
@@ -35,10 +85,10 @@ func (app *application) showPlaylistHandler(w http.ResponseWriter, r *http.Reque
 }
 
 func (app *application) createPlaylistHandler(w http.ResponseWriter, r *http.Request) {
-	//How do we create a playlist
 	var input struct {
-		Name   string `json:"name"`
-		UserID int64  `json:"user_id"`
+		Name       string           `json:"name"`
+		Visibility string           `json:"visibility"`
+		Rules      *data.SmartRules `json:"rules"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -47,24 +97,28 @@ func (app *application) createPlaylistHandler(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	_, err = app.models.UserModel.GetById(input.UserID)
-	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.badRequestResponse(w, r, err)
-			return
-		default:
-			app.serverErrorResponse(w, r, err)
-			return
-		}
-	}
+	user := app.getUserContext(r)
 
 	playlist := data.Playlist{}
 	playlist.Name = input.Name
-	playlist.UserID = input.UserID
+	playlist.UserID = user.ID
+	playlist.Visibility = input.Visibility
+	playlist.Rules = input.Rules
+	if playlist.Visibility == "" {
+		playlist.Visibility = "private"
+	}
 
 	v := validator.New()
 
+	if playlist.Rules != nil {
+		err = app.checkSmartRules(v, &playlist)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	data.ValidateVisibility(v, playlist.Visibility)
 	if data.ValidateName(v, playlist.Name); !v.Valid() {
 		app.failedInvalidationResponse(w, r, v.ErrorMap)
 		return
@@ -77,7 +131,7 @@ func (app *application) createPlaylistHandler(w http.ResponseWriter, r *http.Req
 	}
 
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/playlist/%d", playlist.ID))
+	headers.Set("Location", fmt.Sprintf("/v1/playlists/show/playlist/%d", playlist.ID))
 
 	err = app.writeJSON(w, http.StatusCreated, envelope{"playlist": playlist}, headers)
 	if err != nil {
@@ -93,7 +147,12 @@ func (app *application) deletePlaylistHandler(w http.ResponseWriter, r *http.Req
 		app.notFoundResponse(w, r)
 		return
 	}
-	err = app.models.PlaylistModel.Delete(id)
+	playlist, ok := app.readPlaylist(w, r, id, data.PlaylistOwner)
+	if !ok {
+		return
+	}
+
+	err = app.models.PlaylistModel.Delete(playlist.ID, playlist.UserID)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -114,7 +173,9 @@ func (app *application) deletePlaylistHandler(w http.ResponseWriter, r *http.Req
 }
 
 func (app *application) listPlaylistHandler(w http.ResponseWriter, r *http.Request) {
-	playlists, err := app.models.PlaylistModel.GetAll(1)
+	user := app.getUserContext(r)
+
+	playlists, err := app.models.PlaylistModel.GetAll(user.ID)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -129,31 +190,62 @@ func (app *application) updatePlaylistHandler(w http.ResponseWriter, r *http.Req
 	id, err := app.readIDParam(r)
 	if err != nil {
 		app.notFoundResponse(w, r)
+		return
 	}
 
+	// Rules is kept raw to tell a missing field, which leaves the rules
+	// alone, from null, which turns the playlist back into a regular one.
 	var input struct {
-		Name string `json:"name"`
+		Name       *string         `json:"name"`
+		Visibility *string         `json:"visibility"`
+		Rules      json.RawMessage `json:"rules"`
 	}
 
-	playlist, err := app.models.PlaylistModel.Get(id)
+	err = app.readJSON(w, r, &input)
 	if err != nil {
-		switch {
-		case errors.Is(err, data.ErrRecordNotFound):
-			app.notFoundResponse(w, r)
-		default:
-			app.serverErrorResponse(w, r, err)
-		}
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	playlist, ok := app.readPlaylist(w, r, id, data.PlaylistOwner)
+	if !ok {
 		return
 	}
 
+	if input.Name != nil {
+		playlist.Name = *input.Name
+	}
+
+	if input.Visibility != nil {
+		playlist.Visibility = *input.Visibility
+	}
+
+	if input.Rules != nil {
+		playlist.Rules = nil
+
+		err = json.Unmarshal(input.Rules, &playlist.Rules)
+		if err != nil {
+			app.badRequestResponse(w, r, fmt.Errorf("body contains invalid rules: %w", err))
+			return
+		}
+	}
+
 	v := validator.New()
-	if data.ValidateName(v, input.Name); !v.Valid() {
+
+	if playlist.Rules != nil {
+		err = app.checkSmartRules(v, playlist)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	data.ValidateVisibility(v, playlist.Visibility)
+	if data.ValidateName(v, playlist.Name); !v.Valid() {
 		app.failedInvalidationResponse(w, r, v.ErrorMap)
 		return
 	}
 
-	playlist.Name = input.Name
-
 	err = app.models.PlaylistModel.Update(playlist)
 	if err != nil {
 		switch {
@@ -161,8 +253,8 @@ func (app *application) updatePlaylistHandler(w http.ResponseWriter, r *http.Req
 			app.editConflictResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
-			return
 		}
+		return
 	}
 	err = app.writeJSON(w, http.StatusOK, envelope{"playlist": playlist}, nil)
 	if err != nil {
@@ -174,6 +266,7 @@ func (app *application) addSongToPlaylistHandler(w http.ResponseWriter, r *http.
 	var input struct {
 		SongID     int64 `json:"song_id"`
 		PlaylistID int64 `json:"playlist_id"`
+		Position   int   `json:"position"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -182,6 +275,16 @@ func (app *application) addSongToPlaylistHandler(w http.ResponseWriter, r *http.
 		return
 	}
 
+	playlist, ok := app.readPlaylist(w, r, input.PlaylistID, data.PlaylistEditor)
+	if !ok {
+		return
+	}
+
+	if playlist.Rules != nil {
+		app.smartPlaylistResponse(w, r)
+		return
+	}
+
 	_, err = app.models.SongModel.Get(input.SongID)
 	if err != nil {
 		switch {
@@ -194,12 +297,29 @@ func (app *application) addSongToPlaylistHandler(w http.ResponseWriter, r *http.
 		}
 	}
 
-	err = app.models.PlaylistModel.InsertSong(input.SongID, input.PlaylistID)
+	v := validator.New()
+
+	if data.ValidatePosition(v, input.Position); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	position, err := app.models.PlaylistModel.InsertSong(input.SongID, playlist.ID, app.getUserContext(r).ID, input.Position)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrNotPermitted):
+			app.notAuthorizedResponse(w, r)
+		case errors.Is(err, data.ErrInvalidPosition):
+			v.Add("position", "must be between 1 and one past the last song")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "song has been added to playlist"}, nil)
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "song has been added to playlist", "position": position}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -217,9 +337,26 @@ func (app *application) removeSongFromPlaylistHandler(w http.ResponseWriter, r *
 		return
 	}
 
-	err = app.models.PlaylistModel.DeleteSongFromPlaylist(song_id, playlist_id)
+	playlist, ok := app.readPlaylist(w, r, playlist_id, data.PlaylistEditor)
+	if !ok {
+		return
+	}
+
+	if playlist.Rules != nil {
+		app.smartPlaylistResponse(w, r)
+		return
+	}
+
+	err = app.models.PlaylistModel.DeleteSongFromPlaylist(song_id, playlist.ID, app.getUserContext(r).ID)
 	if err != nil {
-		app.serverErrorResponse(w, r, err)
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrNotPermitted):
+			app.notAuthorizedResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
 		return
 	}
 	err = app.writeJSON(w, http.StatusOK, envelope{"message": "song has been deleted from playlist successfully"}, nil)
@@ -237,6 +374,7 @@ func (app *application) showSongsFromPlaylistHandler(w http.ResponseWriter, r *h
 	var input struct {
 		Name   string
 		Artist string
+		Genres data.GenreFilter
 		data.Filters
 	}
 
@@ -246,18 +384,33 @@ func (app *application) showSongsFromPlaylistHandler(w http.ResponseWriter, r *h
 
 	input.Name = app.readString(qs, "name", "")
 	input.Artist = app.readString(qs, "artist", "")
+	input.Genres.Genres = data.NormalizeGenres(app.readCSV(qs, "genres", []string{}))
+	input.Genres.Match = app.readString(qs, "genres_match", "all")
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "name", "artist", "-id", "-name", "-artist"}
+	input.Filters.Sort = app.readString(qs, "sort", "position")
+	input.Filters.SortSafelist = []string{"position", "id", "name", "artist", "-position", "-id", "-name", "-artist"}
+
+	data.ValidateGenreFilter(v, input.Genres)
 
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedInvalidationResponse(w, r, v.ErrorMap)
 		return
 	}
 
-	songs, err := app.models.PlaylistModel.GetSongsFromPlaylist(playlist_id, input.Artist, input.Name, input.Filters)
+	playlist, ok := app.readPlaylist(w, r, playlist_id, data.PlaylistViewer)
+	if !ok {
+		return
+	}
+
+	var songs []*data.PlaylistSong
+
+	if playlist.Rules != nil {
+		songs, err = app.models.PlaylistModel.GetSmartSongs(playlist, input.Artist, input.Name, input.Genres, input.Filters)
+	} else {
+		songs, err = app.models.PlaylistModel.GetSongsFromPlaylist(playlist.ID, input.Artist, input.Name, input.Genres, input.Filters)
+	}
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -268,3 +421,250 @@ func (app *application) showSongsFromPlaylistHandler(w http.ResponseWriter, r *h
 	}
 
 }
+
+func (app *application) reorderPlaylistSongsHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Moves []data.PlaylistMove `json:"moves"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePlaylistMoves(v, input.Moves); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	playlist, ok := app.readPlaylist(w, r, id, data.PlaylistEditor)
+	if !ok {
+		return
+	}
+
+	if playlist.Rules != nil {
+		app.smartPlaylistResponse(w, r)
+		return
+	}
+
+	err = app.models.PlaylistModel.MoveSongs(playlist.ID, app.getUserContext(r).ID, input.Moves)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		case errors.Is(err, data.ErrNotPermitted):
+			app.notAuthorizedResponse(w, r)
+		case errors.Is(err, data.ErrInvalidPosition):
+			v.Add("moves", "positions must refer to songs in the playlist")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "playlist has been reordered"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPublicPlaylistsHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name string
+		data.Filters
+	}
+
+	v := validator.New()
+
+	qs := r.URL.Query()
+
+	input.Name = app.readString(qs, "name", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.Sort = app.readString(qs, "sort", "id")
+	input.Filters.SortSafelist = []string{"id", "name", "-id", "-name"}
+
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	playlists, err := app.models.PlaylistModel.GetAllPublic(input.Name, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"playlists": playlists}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listPlaylistMembersHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	playlist, ok := app.readPlaylist(w, r, id, data.PlaylistViewer)
+	if !ok {
+		return
+	}
+
+	// Who a playlist is shared with is only for the people it is shared
+	// with, not for anyone who found a public or unlisted playlist.
+	if !playlist.Shared() {
+		app.notAuthorizedResponse(w, r)
+		return
+	}
+
+	members, err := app.models.PlaylistModel.GetMembers(playlist.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"members": members}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// invitePlaylistMemberHandler shares a playlist with another user by email.
+// Inviting an existing member changes their role.
+func (app *application) invitePlaylistMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Email string `json:"email"`
+		Role  string `json:"role"`
+	}
+
+	err = app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.Role == "" {
+		input.Role = data.PlaylistViewer
+	}
+
+	v := validator.New()
+
+	data.ValidateEmail(v, input.Email)
+	if data.ValidateMemberRole(v, input.Role); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	playlist, ok := app.readPlaylist(w, r, id, data.PlaylistOwner)
+	if !ok {
+		return
+	}
+
+	// The response is the same whether or not the address belongs to an
+	// account, so inviting cannot be used to find out who is registered.
+	member, err := app.models.UserModel.GetByEmail(input.Email)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if member != nil {
+		if member.ID == playlist.UserID {
+			v.Add("email", "the owner of a playlist cannot be invited to it")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+			return
+		}
+
+		err = app.models.PlaylistModel.AddMember(playlist.ID, member.ID, input.Role)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, http.StatusAccepted, envelope{"message": "if an account with this email address exists it has been given access to the playlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) leavePlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.getUserContext(r)
+
+	err = app.models.PlaylistModel.RemoveMember(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have left the playlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) removePlaylistMemberHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	userID, err := app.readParamID(r, "user_id")
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	playlist, ok := app.readPlaylist(w, r, id, data.PlaylistOwner)
+	if !ok {
+		return
+	}
+
+	err = app.models.PlaylistModel.RemoveMember(playlist.ID, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "member has been removed from the playlist"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/internal/playlistfile"
+	"github.com/Arkitecth/apollo/validator"
+)
+
+const (
+	maxImportSize    = 1 << 20
+	maxImportEntries = 1000
+)
+
+var (
+	streamPathRX = regexp.MustCompile(`/v1/songs/(\d+)/stream$`)
+	audioPathRX  = regexp.MustCompile(`(?:^|/)audio/([a-f0-9]{64})(?:\.[A-Za-z0-9]+)?$`)
+	filenameRX   = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+)
+
+// unmatchedEntry reports a line of an imported file that no song was found
+// for. Entry is the 1-based index of the track in the file.
+type unmatchedEntry struct {
+	Entry    int    `json:"entry"`
+	Line     int    `json:"line,omitempty"`
+	Location string `json:"location,omitempty"`
+	Title    string `json:"title,omitempty"`
+	Artist   string `json:"artist,omitempty"`
+}
+
+// streamURL is the absolute URL a song is streamed from by this API.
+func (app *application) streamURL(songID int64) string {
+	return fmt.Sprintf("%s/v1/songs/%d/stream", strings.TrimRight(app.config.baseURL, "/"), songID)
+}
+
+func (app *application) exportPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	v := validator.New()
+
+	format := app.readString(r.URL.Query(), "format", playlistfile.M3U8)
+
+	if v.Check(!validator.PermittedValue(format, playlistfile.Formats...), "format", "must be one of m3u8, pls, xspf or json"); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	playlist, ok := app.readPlaylist(w, r, id, data.PlaylistViewer)
+	if !ok {
+		return
+	}
+
+	songs, err := app.exportedSongs(playlist)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	file := &playlistfile.Playlist{Title: playlist.Name, Entries: make([]playlistfile.Entry, 0, len(songs))}
+	for _, song := range songs {
+		file.Entries = append(file.Entries, playlistfile.Entry{
+			Location:    app.streamURL(song.ID),
+			Title:       song.Name,
+			Artist:      song.Artist,
+			Duration:    song.Duration,
+			ContentHash: song.ContentHash,
+		})
+	}
+
+	var buf bytes.Buffer
+
+	err = playlistfile.Encode(&buf, format, file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	filename := strings.Trim(filenameRX.ReplaceAllString(playlist.Name, "_"), "_")
+	if filename == "" {
+		filename = fmt.Sprintf("playlist-%d", playlist.ID)
+	}
+
+	w.Header().Set("Content-Type", playlistfile.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename+"."+format))
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
+
+// exportedSongs returns every song of a playlist in order. Smart playlists
+// are evaluated, regular ones also carry the content hash of their songs.
+func (app *application) exportedSongs(playlist *data.Playlist) ([]*data.Song, error) {
+	if playlist.Rules == nil {
+		return app.models.SongModel.GetAllSongs(playlist.ID)
+	}
+
+	filters := data.Filters{Page: 1, PageSize: playlist.Rules.Limit, Sort: "position", SortSafelist: []string{"position"}}
+
+	smart, err := app.models.PlaylistModel.GetSmartSongs(playlist, "", "", data.GenreFilter{Genres: []string{}, Match: "all"}, filters)
+	if err != nil {
+		return nil, err
+	}
+
+	songs := make([]*data.Song, 0, len(smart))
+	for _, song := range smart {
+		songs = append(songs, song.Song)
+	}
+
+	return songs, nil
+}
+
+// importPlaylistHandler creates a private playlist from an uploaded playlist
+// file. The format is taken from the format query parameter, then the
+// Content-Type, then the file itself. Entries no song was found for are left
+// out and reported back.
+func (app *application) importPlaylistHandler(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("playlist file must not exceed %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(bytes.TrimSpace(body)) == 0 {
+		app.badRequestResponse(w, r, errors.New("playlist file must not be empty"))
+		return
+	}
+
+	qs := r.URL.Query()
+
+	format := app.readString(qs, "format", "")
+	if format == "" {
+		format = playlistfile.FormatFromContentType(r.Header.Get("Content-Type"))
+	}
+	if format == "" {
+		format = playlistfile.Detect(body)
+	}
+
+	v := validator.New()
+
+	if v.Check(!validator.PermittedValue(format, playlistfile.Formats...), "format", "must be one of m3u8, pls, xspf or json"); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	file, err := playlistfile.Decode(bytes.NewReader(body), format)
+	if err != nil {
+		switch {
+		case errors.Is(err, playlistfile.ErrMalformed):
+			app.badRequestResponse(w, r, fmt.Errorf("playlist file is not valid %s", format))
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	user := app.getUserContext(r)
+
+	playlist := data.Playlist{}
+	playlist.Name = app.readString(qs, "name", file.Title)
+	playlist.UserID = user.ID
+	playlist.Visibility = "private"
+	if playlist.Name == "" {
+		playlist.Name = "Imported playlist"
+	}
+
+	v.Check(len(file.Entries) == 0, "entries", "must contain at least one track")
+	v.Check(len(file.Entries) > maxImportEntries, "entries", fmt.Sprintf("must not contain more than %d tracks", maxImportEntries))
+
+	if data.ValidateName(v, playlist.Name); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	matches, err := app.matchPlaylistEntries(file.Entries)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	songIDs := []int64{}
+	unmatched := []unmatchedEntry{}
+
+	for i, entry := range file.Entries {
+		if matches[i] == 0 {
+			unmatched = append(unmatched, unmatchedEntry{
+				Entry:    i + 1,
+				Line:     entry.Line,
+				Location: entry.Location,
+				Title:    entry.Title,
+				Artist:   entry.Artist,
+			})
+			continue
+		}
+
+		songIDs = append(songIDs, matches[i])
+	}
+
+	err = app.models.PlaylistModel.InsertWithSongs(&playlist, songIDs)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/playlists/show/playlist/%d", playlist.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"playlist": playlist, "matched": len(songIDs), "unmatched": unmatched}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// playlistEntryKeys are the ways an imported entry can refer to a song.
+type playlistEntryKeys struct {
+	hash     string
+	streamID int64
+}
+
+func readPlaylistEntryKeys(entry playlistfile.Entry) playlistEntryKeys {
+	keys := playlistEntryKeys{hash: strings.ToLower(entry.ContentHash)}
+
+	if entry.Location != "" {
+		location := entry.Location
+		if u, err := url.Parse(location); err == nil {
+			location = u.Path
+		}
+
+		if match := streamPathRX.FindStringSubmatch(location); match != nil {
+			keys.streamID, _ = strconv.ParseInt(match[1], 10, 64)
+		}
+		if match := audioPathRX.FindStringSubmatch(location); match != nil && keys.hash == "" {
+			keys.hash = match[1]
+		}
+	}
+
+	if !validator.Matches(keys.hash, validator.SHA256RX) {
+		keys.hash = ""
+	}
+
+	return keys
+}
+
+// matchPlaylistEntries finds the song each imported entry refers to, trying
+// the content hash, a stream URL of this API, the stored song URL and finally
+// the title and artist. Every kind of lookup is one query for all entries.
+// The result holds a song id per entry, 0 where nothing matched.
+func (app *application) matchPlaylistEntries(entries []playlistfile.Entry) ([]int64, error) {
+	keys := make([]playlistEntryKeys, len(entries))
+
+	var (
+		hashes    []string
+		streamIDs []int64
+		locations []string
+		titles    []string
+		artists   []string
+	)
+
+	for i, entry := range entries {
+		keys[i] = readPlaylistEntryKeys(entry)
+
+		if keys[i].hash != "" {
+			hashes = append(hashes, keys[i].hash)
+		}
+		if keys[i].streamID > 0 {
+			streamIDs = append(streamIDs, keys[i].streamID)
+		}
+		if entry.Location != "" {
+			locations = append(locations, entry.Location)
+		}
+
+		// Every entry is sent, so the index of a title is the index of its
+		// entry. An empty title never matches.
+		titles = append(titles, entry.Title)
+		artists = append(artists, entry.Artist)
+	}
+
+	byHash, err := app.models.SongModel.GetIDsByContentHash(hashes)
+	if err != nil {
+		return nil, err
+	}
+
+	byStreamID, err := app.models.SongModel.GetExistingIDs(streamIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	byLocation, err := app.models.SongModel.GetIDsBySongURL(locations)
+	if err != nil {
+		return nil, err
+	}
+
+	byTitle, err := app.models.SongModel.GetIDsByNameArtist(titles, artists)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := make([]int64, len(entries))
+
+	for i, entry := range entries {
+		switch {
+		case byHash[keys[i].hash] != 0:
+			matches[i] = byHash[keys[i].hash]
+		case byStreamID[keys[i].streamID] != 0:
+			matches[i] = byStreamID[keys[i].streamID]
+		case byLocation[entry.Location] != 0:
+			matches[i] = byLocation[entry.Location]
+		case entry.Title != "":
+			matches[i] = byTitle[int64(i)]
+		}
+	}
+
+	return matches, nil
+}
@@ -4,6 +4,7 @@ import (
 	"expvar"
 	"net/http"
 
+	"github.com/Arkitecth/apollo/internal/storage"
 	"github.com/julienschmidt/httprouter"
 )
 
@@ -14,30 +15,107 @@ func (app *application) routes() http.Handler {
 
 	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
 	router.HandlerFunc(http.MethodDelete, "/v1/songs/:id", app.requireAuthorizedUser("songs:delete", app.deleteSongHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/songs/:id", app.requireAuthorizedUser("songs:write", app.updateSongHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/songs", app.requireAuthorizedUser("songs:create", app.createSongHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/upload/songs", app.requireAuthorizedUser("songs:upload", app.uploadSongHandler))
 
+	router.HandlerFunc(http.MethodPost, "/v1/uploads", app.requireAuthorizedUser("songs:upload", app.createUploadHandler))
+	router.HandlerFunc(http.MethodHead, "/v1/uploads/:id", app.requireAuthorizedUser("songs:upload", app.showUploadHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/uploads/:id", app.requireAuthorizedUser("songs:upload", app.writeUploadChunkHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/uploads/:id/finalize", app.requireAuthorizedUser("songs:upload", app.finalizeUploadHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/uploads/:id", app.requireAuthorizedUser("songs:upload", app.deleteUploadHandler))
+
 	router.HandlerFunc(http.MethodGet, "/v1/songs/:id", app.showSongHandler)
 	router.HandlerFunc(http.MethodGet, "/v1/songs", app.listSongsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/stream", app.requireAuthorizedUser("songs:read", app.streamSongHandler))
+	router.HandlerFunc(http.MethodHead, "/v1/songs/:id/stream", app.requireAuthorizedUser("songs:read", app.streamSongHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/songs/:id/artists", app.listSongCreditsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/songs/:id/artists", app.requireAuthorizedUser("songs:write", app.addSongCreditHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/songs/:id/artists/:artist_id", app.requireAuthorizedUser("songs:write", app.removeSongCreditHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/genres", app.listGenresHandler)
+
+	//Artists
+	router.HandlerFunc(http.MethodGet, "/v1/artists", app.listArtistsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/artists/:id", app.showArtistHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/artists/:id/songs", app.showArtistSongsHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/artists", app.requireAuthorizedUser("artists:write", app.createArtistHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/artists/:id", app.requireAuthorizedUser("artists:write", app.updateArtistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/artists/:id", app.requireAuthorizedUser("artists:write", app.deleteArtistHandler))
+
+	//Albums
+	router.HandlerFunc(http.MethodGet, "/v1/albums", app.listAlbumsHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/albums/:id", app.showAlbumHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/albums", app.requireAuthorizedUser("albums:write", app.createAlbumHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/albums/:id", app.requireAuthorizedUser("albums:write", app.updateAlbumHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/albums/:id", app.requireAuthorizedUser("albums:write", app.deleteAlbumHandler))
 
 	//Users
 	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
 	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
+	router.HandlerFunc(http.MethodPut, "/v1/users/password", app.updateUserPasswordHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/sessions", app.requireSessionUser(app.listSessionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/sessions/:id", app.requireSessionUser(app.deleteSessionHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys", app.requireSessionUser(app.listAPIKeysHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/api-keys", app.requireSessionUser(app.createAPIKeyHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/users/me/api-keys/:id", app.requireSessionUser(app.showAPIKeyHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/api-keys/:id", app.requireSessionUser(app.deleteAPIKeyHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp", app.requireSessionUser(app.enrollTOTPHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/users/me/totp/enable", app.requireSessionUser(app.enableTOTPHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/users/me/totp", app.requireSessionUser(app.disableTOTPHandler))
+
+	//Admin
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users", app.requireAuthorizedUser("admin", app.listUsersHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id", app.requireAuthorizedUser("admin", app.showUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id", app.requireAuthorizedUser("admin", app.deleteUserHandler))
+	router.HandlerFunc(http.MethodGet, "/v1/admin/users/:id/permissions", app.requireAuthorizedUser("admin", app.showUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/admin/users/:id/permissions", app.requireAuthorizedUser("admin", app.grantUserPermissionsHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/permissions", app.requireAuthorizedUser("admin", app.revokeUserPermissionsHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/activated", app.requireAuthorizedUser("admin", app.forceActivateUserHandler))
+	router.HandlerFunc(http.MethodPut, "/v1/admin/users/:id/suspended", app.requireAuthorizedUser("admin", app.suspendUserHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/admin/users/:id/tokens", app.requireAuthorizedUser("admin", app.revokeUserTokensHandler))
 
 	//Playlist
 	router.HandlerFunc(http.MethodGet, "/v1/playlists/show/playlist/:id", app.requireActivatedUser(app.showPlaylistHandler))
 	router.HandlerFunc(http.MethodPost, "/v1/playlists/create/playlist", app.requireActivatedUser(app.createPlaylistHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/playlists/update/playlist/:id", app.requireActivatedUser(app.updatePlaylistHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/playlists/delete/playlist/:id", app.requireActivatedUser(app.deletePlaylistHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/playlists/list/playlist", app.requireActivatedUser(app.listPlaylistHandler))
 
+	router.HandlerFunc(http.MethodGet, "/v1/playlists/list/public", app.requireActivatedUser(app.listPublicPlaylistsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/playlists/list/member/:id", app.requireActivatedUser(app.listPlaylistMembersHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/playlists/invite/member/:id", app.requireActivatedUser(app.invitePlaylistMemberHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/playlists/leave/member/:id", app.requireActivatedUser(app.leavePlaylistHandler))
+	router.HandlerFunc(http.MethodDelete, "/v1/playlists/remove/member/:id/:user_id", app.requireActivatedUser(app.removePlaylistMemberHandler))
+
 	router.HandlerFunc(http.MethodPost, "/v1/playlists/add/songs", app.requireActivatedUser(app.addSongToPlaylistHandler))
 	router.HandlerFunc(http.MethodDelete, "/v1/playlists/remove/songs/:song_id/:playlist_id", app.requireActivatedUser(app.removeSongFromPlaylistHandler))
 	router.HandlerFunc(http.MethodGet, "/v1/playlists/show/songs/:id", app.requireActivatedUser(app.showSongsFromPlaylistHandler))
+	router.HandlerFunc(http.MethodPatch, "/v1/playlists/reorder/songs/:id", app.requireActivatedUser(app.reorderPlaylistSongsHandler))
+
+	router.HandlerFunc(http.MethodGet, "/v1/playlists/export/playlist/:id", app.requireActivatedUser(app.exportPlaylistHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/playlists/import", app.requireActivatedUser(app.importPlaylistHandler))
 
 	//Tokens
 	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodDelete, "/v1/tokens/authentication", app.requireAuthenticatedUser(app.deleteAuthenticationTokenHandler))
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/refresh", app.refreshAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/mfa", app.createMFAAuthenticationTokenHandler)
+	router.HandlerFunc(http.MethodGet, "/v1/oidc/login", app.oidcLoginHandler)
+	router.HandlerFunc(http.MethodGet, oidcCallbackPath, app.oidcCallbackHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/activation", app.createActivationTokenHandler)
+	router.HandlerFunc(http.MethodPost, "/v1/tokens/password-reset", app.createPasswordResetTokenHandler)
 
 	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
 
+	// Only cover art is public. Audio is streamed through
+	// /v1/songs/:id/stream, which checks songs:read, and upload chunks are
+	// never served.
+	if local, ok := app.storage.(*storage.Local); ok {
+		router.ServeFiles("/media/*filepath", local.FileSystem("thumbnails/"))
+	}
+
 	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
 }
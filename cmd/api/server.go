@@ -43,7 +43,10 @@ func (app *application) serve() error {
 
 		app.logger.Info("completing background tasks", "addr", srv.Addr)
 
+		close(app.shutdown)
 		app.wg.Wait()
+
+		app.recordSessionUsage()
 		shutdownErr <- nil
 	}()
 
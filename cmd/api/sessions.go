@@ -0,0 +1,128 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/Arkitecth/apollo/internal/data"
+)
+
+const sessionUsageFlushInterval = time.Minute
+
+// sessionUsage collects when authentication tokens, or API keys, were last
+// used so the authenticate middleware does not write to the database on every
+// request.
+// It is flushed in one statement every sessionUsageFlushInterval.
+type sessionUsage struct {
+	mu       sync.Mutex
+	lastUsed map[string]time.Time
+}
+
+func newSessionUsage() *sessionUsage {
+	return &sessionUsage{lastUsed: make(map[string]time.Time)}
+}
+
+func (s *sessionUsage) touch(hash []byte) {
+	s.mu.Lock()
+	s.lastUsed[string(hash)] = time.Now()
+	s.mu.Unlock()
+}
+
+func (s *sessionUsage) drain() map[string]time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lastUsed := s.lastUsed
+	s.lastUsed = make(map[string]time.Time)
+
+	return lastUsed
+}
+
+func (app *application) recordSessionUsage() {
+	err := app.models.TokenModel.TouchAll(app.sessionUsage.drain())
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+
+	err = app.models.APIKeyModel.TouchAll(app.apiKeyUsage.drain())
+	if err != nil {
+		app.logger.Error(err.Error())
+	}
+}
+
+// clientUserAgent is the User-Agent a session is listed with, cut short so a
+// client cannot store arbitrarily large values.
+func clientUserAgent(r *http.Request) string {
+	userAgent := r.UserAgent()
+	if len(userAgent) > 512 {
+		userAgent = userAgent[:512]
+	}
+	return userAgent
+}
+
+func (app *application) deleteAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	token := app.getTokenContext(r)
+	if token == nil {
+		app.authenticationRequiredResponse(w, r)
+		return
+	}
+
+	err := app.models.TokenModel.DeleteFamily(token.Hash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "you have been logged out"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) listSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+
+	var currentHash []byte
+	if token := app.getTokenContext(r); token != nil {
+		currentHash = token.Hash
+	}
+
+	sessions, err := app.models.TokenModel.GetSessions(user.ID, currentHash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"sessions": sessions}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteSessionHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	user := app.getUserContext(r)
+
+	err = app.models.TokenModel.DeleteSession(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "session has been revoked"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -3,9 +3,14 @@ package main
 import (
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
+	"path"
+	"time"
 
 	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/internal/metadata"
+	"github.com/Arkitecth/apollo/internal/storage"
 	"github.com/Arkitecth/apollo/validator"
 )
 
@@ -29,7 +34,10 @@ func (app *application) showSongHandler(w http.ResponseWriter, r *http.Request)
 		}
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, nil)
+	headers := make(http.Header)
+	headers.Set("ETag", songETag(song))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -41,6 +49,7 @@ func (app *application) listSongsHandler(w http.ResponseWriter, r *http.Request)
 	var input struct {
 		Name   string
 		Artist string
+		Genres data.GenreFilter
 		data.Filters
 	}
 
@@ -50,18 +59,22 @@ func (app *application) listSongsHandler(w http.ResponseWriter, r *http.Request)
 
 	input.Name = app.readString(qs, "name", "")
 	input.Artist = app.readString(qs, "artist", "")
+	input.Genres.Genres = data.NormalizeGenres(app.readCSV(qs, "genres", []string{}))
+	input.Genres.Match = app.readString(qs, "genres_match", "all")
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
 	input.Filters.Sort = app.readString(qs, "sort", "id")
 	input.Filters.SortSafelist = []string{"id", "name", "artist", "-id", "-name", "-artist"}
 
+	data.ValidateGenreFilter(v, input.Genres)
+
 	if data.ValidateFilters(v, input.Filters); !v.Valid() {
 		app.failedInvalidationResponse(w, r, v.ErrorMap)
 		return
 	}
 
-	songs, err := app.models.SongModel.GetAll(input.Artist, input.Name, input.Filters)
+	songs, err := app.models.SongModel.GetAll(input.Artist, input.Name, input.Genres, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -75,25 +88,220 @@ func (app *application) listSongsHandler(w http.ResponseWriter, r *http.Request)
 
 }
 
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	genres, err := app.models.SongModel.GetGenres()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"genres": genres}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) streamSongHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	song, err := app.models.SongModel.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	key := song.MediaKey
+	if key == "" {
+		var ok bool
+		key, ok = storage.KeyFromURL(app.storage, song.SongURL)
+		if !ok {
+			app.notFoundResponse(w, r)
+			return
+		}
+	}
+
+	info, err := app.storage.Stat(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	object, err := app.storage.Get(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer object.Close()
+
+	// The server WriteTimeout is sized for JSON responses, a full track to a
+	// slow client takes far longer than that.
+	err = http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.logErrors(r, err)
+	}
+
+	if info.ContentType != "" {
+		w.Header().Set("Content-Type", info.ContentType)
+	}
+	if info.ETag != "" {
+		w.Header().Set("ETag", info.ETag)
+	}
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+
+	http.ServeContent(w, r, path.Base(key), info.ModTime, object)
+}
+
 func (app *application) uploadSongHandler(w http.ResponseWriter, r *http.Request) {
-	url, err := app.uploadS3(r, "file")
+	file, handler, err := app.readFile(r, "file")
+	if err != nil {
+		switch {
+		case errors.Is(err, http.ErrMissingFile), errors.Is(err, http.ErrNotMultipart):
+			app.badRequestResponse(w, r, err)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer file.Close()
+
+	v := validator.New()
+
+	md, err := metadata.Read(file)
+	if err != nil {
+		switch {
+		case errors.Is(err, metadata.ErrUnsupportedFormat):
+			v.Add("file", "must be an mp3, flac, ogg or m4a audio file")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		case errors.Is(err, metadata.ErrMalformed):
+			v.Add("file", "audio file is corrupt or truncated")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	_, err = file.Seek(0, io.SeekStart)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	hash, err := contentHash(file)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	claim, err := app.acquireMediaObject(hash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer app.settleMediaClaim(claim)
+
+	stored := claim.object != nil
+	if !stored {
+		claim.object = app.newMediaObject(hash, audioKey(hash, handler.Filename), handler.Size, md)
+	}
+
+	object := claim.object
+
+	song := app.songFromMetadata(md, handler.Filename)
+	app.applySongOverrides(song, r.FormValue("name"), r.FormValue("artist"), app.readCSV(r.MultipartForm.Value, "genres", nil))
+	app.setSongMedia(song, object)
+
+	album := app.songAlbum(song, r.FormValue("album"), md)
+
+	data.ValidateSong(v, song)
+	if validateSongAlbum(v, album); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	if !stored {
+		_, err = file.Seek(0, io.SeekStart)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.storage.Put(r.Context(), object.Key, file, object.Size, object.ContentType)
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrInvalidKey):
+				app.badRequestResponse(w, r, err)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		claim.written = true
+
+		err = app.storeCoverArt(r.Context(), object, md.Picture)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.setSongAlbum(song, album)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "song uploaded sucessfully", "url": url}, nil)
+
+	err = app.models.SongModel.InsertWithMedia(song, object)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
+
+	claim.used = true
+
+	app.creditNewSong(r, song)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/songs/%d", song.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"song": song}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
 
 func (app *application) createSongHandler(w http.ResponseWriter, r *http.Request) {
 	var input struct {
-		Artist    string `json:"artist"`
-		Name      string `json:"name"`
-		Thumbnail string `json:"thumbnail"`
-		SongURL   string `json:"song_url"`
+		Artist      string   `json:"artist"`
+		Name        string   `json:"name"`
+		Thumbnail   string   `json:"thumbnail"`
+		SongURL     string   `json:"song_url"`
+		ContentHash string   `json:"content_hash"`
+		AlbumID     int64    `json:"album_id"`
+		TrackNumber int      `json:"track_number"`
+		DiscNumber  int      `json:"disc_number"`
+		Genres      []string `json:"genres"`
 	}
 
 	err := app.readJSON(w, r, &input)
@@ -107,20 +315,64 @@ func (app *application) createSongHandler(w http.ResponseWriter, r *http.Request
 	song.Name = input.Name
 	song.SongURL = input.SongURL
 	song.Thumbnail = input.Thumbnail
+	song.AlbumID = input.AlbumID
+	song.TrackNumber = input.TrackNumber
+	song.DiscNumber = input.DiscNumber
+	song.Genres = data.NormalizeGenres(input.Genres)
 
 	v := validator.New()
 
+	// A song can point at audio that was already uploaded by referencing its
+	// content hash instead of a URL.
+	claim := &mediaClaim{}
+	if input.ContentHash != "" {
+		if data.ValidateContentHash(v, input.ContentHash); !v.Valid() {
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+			return
+		}
+
+		claim, err = app.acquireMediaObject(input.ContentHash)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		defer app.settleMediaClaim(claim)
+
+		if claim.object == nil {
+			v.Add("content_hash", "no uploaded file matches this hash")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+			return
+		}
+
+		song.SongURL = app.storage.URL(claim.object.Key)
+		if song.Thumbnail == "" && claim.object.CoverKey != "" {
+			song.Thumbnail = app.storage.URL(claim.object.CoverKey)
+		}
+	}
+
 	if data.ValidateSong(v, &song); !v.Valid() {
 		app.failedInvalidationResponse(w, r, v.ErrorMap)
 		return
 	}
 
-	err = app.models.SongModel.Insert(&song)
+	if !app.songAlbumExists(w, r, &song) {
+		return
+	}
+
+	if claim.object != nil {
+		err = app.models.SongModel.InsertWithMedia(&song, claim.object)
+	} else {
+		err = app.models.SongModel.Insert(&song)
+	}
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	claim.used = true
+
+	app.creditNewSong(r, &song)
+
 	headers := make(http.Header)
 	headers.Set("Location", fmt.Sprintf("/v1/songs/%d", song.ID))
 
@@ -164,6 +416,12 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	expectedVersion, err := app.readExpectedVersion(r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
 	song, err := app.models.SongModel.Get(id)
 	if err != nil {
 		switch {
@@ -176,11 +434,22 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	if expectedVersion != 0 && expectedVersion != song.Version {
+		app.editConflictResponse(w, r)
+		return
+	}
+
 	var input struct {
-		Artist    *string `json:"artist"`
-		Name      *string `json:"name"`
-		SongURL   *string `json:"song_url"`
-		Thumbnail *string `json:"thumbnail"`
+		Artist      *string  `json:"artist"`
+		Name        *string  `json:"name"`
+		SongURL     *string  `json:"song_url"`
+		Thumbnail   *string  `json:"thumbnail"`
+		AlbumID     *int64   `json:"album_id"`
+		TrackNumber *int     `json:"track_number"`
+		DiscNumber  *int     `json:"disc_number"`
+		Year        *int     `json:"year"`
+		Duration    *int     `json:"duration"`
+		Genres      []string `json:"genres"`
 	}
 
 	err = app.readJSON(w, r, &input)
@@ -189,6 +458,8 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 		return
 	}
 
+	previousArtist := song.Artist
+
 	if input.Name != nil {
 		song.Name = *input.Name
 	}
@@ -197,7 +468,12 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 		song.Artist = *input.Artist
 	}
 
+	v := validator.New()
+
 	if input.SongURL != nil {
+		// An uploaded song is streamed from its media object, the url only
+		// describes it. Pointing it elsewhere would not change the audio.
+		v.Check(song.MediaKey != "" && *input.SongURL != song.SongURL, "song_url", "cannot be changed for an uploaded song")
 		song.SongURL = *input.SongURL
 	}
 
@@ -205,13 +481,39 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 		song.Thumbnail = *input.Thumbnail
 	}
 
-	v := validator.New()
+	if input.AlbumID != nil {
+		song.AlbumID = *input.AlbumID
+	}
+
+	if input.TrackNumber != nil {
+		song.TrackNumber = *input.TrackNumber
+	}
+
+	if input.DiscNumber != nil {
+		song.DiscNumber = *input.DiscNumber
+	}
+
+	if input.Year != nil {
+		song.Year = *input.Year
+	}
+
+	if input.Duration != nil {
+		song.Duration = *input.Duration
+	}
+
+	if input.Genres != nil {
+		song.Genres = data.NormalizeGenres(input.Genres)
+	}
 
 	if data.ValidateSong(v, song); !v.Valid() {
 		app.failedInvalidationResponse(w, r, v.ErrorMap)
 		return
 	}
 
+	if input.AlbumID != nil && !app.songAlbumExists(w, r, song) {
+		return
+	}
+
 	err = app.models.SongModel.Update(song)
 	if err != nil {
 		switch {
@@ -222,9 +524,49 @@ func (app *application) updateSongHandler(w http.ResponseWriter, r *http.Request
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, nil)
+
+	if data.NormalizeArtistName(song.Artist) != data.NormalizeArtistName(previousArtist) {
+		err = app.replacePrimaryArtist(song)
+		if err != nil {
+			app.logErrors(r, err)
+		}
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", songETag(song))
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"song": song}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+// songAlbumExists checks that the album a song points at exists, sending the
+// error response itself when it does not.
+func (app *application) songAlbumExists(w http.ResponseWriter, r *http.Request, song *data.Song) bool {
+	if song.AlbumID == 0 {
+		return true
+	}
+
+	_, err := app.models.AlbumModel.Get(song.AlbumID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v := validator.New()
+			v.Add("album_id", "album does not exist")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return false
+	}
+
+	return true
+}
+
+// songETag is the entity tag of a song's JSON representation, derived from
+// its version so clients can send it back in If-Match.
+func songETag(song *data.Song) string {
+	return fmt.Sprintf(`"%d"`, song.Version)
+}
@@ -7,9 +7,20 @@ import (
 
 	"github.com/Arkitecth/apollo/internal/data"
 	"github.com/Arkitecth/apollo/validator"
+	"github.com/tomasen/realip"
 )
 
+// createAuthenticationTokenHandler logs a user in with their password. Wrong
+// passwords are counted per account, which is locked for a while after
+// too many in a row, and per IP, which is told to back off.
 func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	ip := realip.FromRequest(r)
+
+	if wait, blocked := app.loginThrottle.blocked(ip); blocked {
+		app.loginThrottledResponse(w, r, wait)
+		return
+	}
+
 	var input struct {
 		Email    string `json:"email"`
 		Password string `json:"password"`
@@ -31,10 +42,12 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
-	user, err := app.models.UserModel.GetByEmail(input.Email)
+	user, attempts, err := app.models.UserModel.GetForLogin(input.Email)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
+			data.DummyPasswordMatches(input.Password)
+			app.loginThrottle.fail(ip)
 			app.invalidCredentialsResponse(w, r)
 		default:
 			app.serverErrorResponse(w, r, err)
@@ -42,26 +55,265 @@ func (app *application) createAuthenticationTokenHandler(w http.ResponseWriter,
 		return
 	}
 
+	// A locked account answers like a wrong password, so locking cannot be
+	// used to find out whether an email has an account. Its owner is told by
+	// email.
+	if attempts.Locked(time.Now()) {
+		data.DummyPasswordMatches(input.Password)
+		app.loginThrottle.fail(ip)
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
 	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 	if !match {
+		app.loginThrottle.fail(ip)
+		app.recordLoginFailure(user)
 		app.invalidCredentialsResponse(w, r)
 		return
 	}
 
-	token, err := app.models.TokenModel.New(user.ID, 24*time.Hour, data.ScopeAuthentication)
+	app.startSession(w, r, user)
+}
+
+// recordLoginFailure counts a wrong password or second-factor code against
+// an account in the background. The response does not wait for it, so a wrong password for an
+// existing account answers as fast as one for an email nobody registered.
+// The owner is emailed when the failure locks the account.
+func (app *application) recordLoginFailure(user *data.User) {
+	app.background(func() {
+		attempts, err := app.models.LoginAttemptModel.RecordFailure(user.ID, app.config.login.lockout)
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		if attempts.Failed != app.config.login.lockout.MaxAttempts || attempts.LockedUntil == nil {
+			return
+		}
+
+		data := map[string]any{
+			"lockedUntil": attempts.LockedUntil.UTC().Format(time.RFC1123),
+		}
+
+		err = app.mailer.Send(user.Email, "account_locked.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+}
+
+// startSession logs in a user whose password, or identity provider login,
+// was checked. With two-factor authentication enabled it only issues an MFA
+// token to be exchanged at createMFAAuthenticationTokenHandler, and failed
+// logins are only forgotten once a code is accepted there. Otherwise a
+// correct password would undo the failures counted for wrong codes.
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *data.User) {
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	twoFactor, err := app.models.TOTPModel.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if twoFactor.Enabled {
+		mfaToken, err := app.models.TokenModel.New(user.ID, mfaTokenTTL, data.ScopeMFA)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		err = app.writeJSON(w, http.StatusCreated, envelope{"mfa_token": mfaToken}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.models.LoginAttemptModel.Reset(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refresh, err := app.models.TokenModel.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, clientUserAgent(r), realip.FromRequest(r))
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token}, nil)
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createPasswordResetTokenHandler emails a password reset token. It answers
+// the same way whether or not the email belongs to an account, so it cannot
+// be used to find out who has one.
+func (app *application) createPasswordResetTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
 		return
 	}
 
+	// The lookup, the token and the email all happen in the background, so
+	// the response takes as long whether or not the email has an account.
+	app.background(func() {
+		user, err := app.models.UserModel.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.Error(err.Error())
+			}
+			return
+		}
+
+		token, err := app.models.TokenModel.New(user.ID, 45*time.Minute, data.ScopePasswordReset)
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		data := map[string]any{
+			"passwordResetToken": token.Plaintext,
+		}
+		err = app.mailer.Send(user.Email, "token_password_reset.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "if an account exists for this email address you will receive an email with password reset instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createActivationTokenHandler sends a new activation token to an account
+// that has not been activated yet, replacing any sent before. Like the
+// password reset route it answers the same way for every email address.
+func (app *application) createActivationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateEmail(v, input.Email); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	// As with password resets everything past validation happens in the
+	// background, so the response time does not reveal the account.
+	app.background(func() {
+		user, err := app.models.UserModel.GetByEmail(input.Email)
+		if err != nil {
+			if !errors.Is(err, data.ErrRecordNotFound) {
+				app.logger.Error(err.Error())
+			}
+			return
+		}
+
+		if user.Activated {
+			return
+		}
+
+		err = app.models.TokenModel.DeleteAllForUsers(data.ScopeActivation, user.ID)
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		token, err := app.models.TokenModel.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+		if err != nil {
+			app.logger.Error(err.Error())
+			return
+		}
+
+		data := map[string]any{
+			"activationToken": token.Plaintext,
+		}
+		err = app.mailer.Send(user.Email, "token_activation.tmpl", data)
+		if err != nil {
+			app.logger.Error(err.Error())
+		}
+	})
+
+	env := envelope{"message": "if an unactivated account exists for this email address you will receive an email with activation instructions"}
+
+	err = app.writeJSON(w, http.StatusAccepted, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// refreshAuthenticationTokenHandler exchanges a refresh token for a new
+// authentication and refresh token. Each refresh token works once.
+func (app *application) refreshAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidatePlaintext(v, input.RefreshToken); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	token, refresh, err := app.models.TokenModel.Rotate(input.RefreshToken, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, clientUserAgent(r), realip.FromRequest(r))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.Add("refresh_token", "invalid or expired refresh token")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		case errors.Is(err, data.ErrTokenReused):
+			app.logger.Warn("refresh token reused, session revoked", "ip", realip.FromRequest(r))
+			v.Add("refresh_token", "invalid or expired refresh token")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
 }
@@ -0,0 +1,294 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/internal/totp"
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/tomasen/realip"
+)
+
+const (
+	totpIssuer = "Apollo"
+
+	mfaTokenTTL = 5 * time.Minute
+)
+
+// checkSecondFactor verifies an authenticator or recovery code and spends
+// it, so neither can be used twice.
+func (app *application) checkSecondFactor(twoFactor *data.TOTP, code string, recoveryCode string) (bool, error) {
+	if !twoFactor.Enabled {
+		return false, nil
+	}
+
+	if recoveryCode != "" {
+		return app.models.TOTPModel.UseRecoveryCode(twoFactor.UserID, recoveryCode)
+	}
+
+	counter, ok := totp.Validate(twoFactor.Secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	return app.models.TOTPModel.UseCounter(twoFactor.UserID, counter)
+}
+
+// enrollTOTPHandler starts setting up two-factor authentication. The secret
+// only takes effect once a code generated from it is sent to
+// enableTOTPHandler.
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.getUserContext(r)
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTPModel.SetSecret(user.ID, secret)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			v := validator.New()
+			v.Add("totp", "two-factor authentication is already enabled")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	env := envelope{
+		"totp": envelope{
+			"secret":           secret,
+			"provisioning_uri": totp.URI(totpIssuer, user.Email, secret),
+		},
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, env, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enableTOTPHandler finishes an enrollment and returns the recovery codes.
+// They are only stored hashed, this is the one time they can be read.
+func (app *application) enableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if v.Check(!validator.Matches(input.Code, data.TOTPCodeRX), "code", "must be 6 digits"); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	user := app.getUserContext(r)
+
+	twoFactor, err := app.models.TOTPModel.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	v.Check(twoFactor.Enabled, "totp", "two-factor authentication is already enabled")
+	v.Check(!twoFactor.Enabled && twoFactor.Secret == "", "totp", "enrollment has not been started")
+
+	if !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	counter, ok := totp.Validate(twoFactor.Secret, input.Code, time.Now())
+	if !ok {
+		v.Add("code", "invalid code")
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	recoveryCodes, err := data.GenerateRecoveryCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.TOTPModel.Enable(user.ID, counter, recoveryCodes)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"recovery_codes": recoveryCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTOTPHandler turns two-factor authentication off. It asks for a code
+// as well, so a stolen session alone cannot remove the second factor.
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	if data.ValidateSecondFactor(v, input.Code, input.RecoveryCode); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	user := app.getUserContext(r)
+
+	twoFactor, err := app.models.TOTPModel.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	if v.Check(!twoFactor.Enabled, "totp", "two-factor authentication is not enabled"); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	ok, err := app.checkSecondFactor(twoFactor, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		v.Add("code", "invalid code")
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	err = app.models.TOTPModel.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusOK, envelope{"message": "two-factor authentication has been disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// createMFAAuthenticationTokenHandler is the second step of logging in with
+// two-factor authentication: it exchanges the MFA token issued for the
+// password and a code for a session. An MFA token is deleted after
+// data.MFAMaxAttempts wrong codes, and every wrong code also counts towards
+// locking the account like a wrong password does.
+func (app *application) createMFAAuthenticationTokenHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		MFAToken     string `json:"mfa_token"`
+		Code         string `json:"code"`
+		RecoveryCode string `json:"recovery_code"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+
+	data.ValidatePlaintext(v, input.MFAToken)
+	data.ValidateSecondFactor(v, input.Code, input.RecoveryCode)
+
+	if !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	user, err := app.models.UserModel.GetUserFromToken(data.ScopeMFA, input.MFAToken)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.Add("mfa_token", "invalid or expired mfa token")
+			app.failedInvalidationResponse(w, r, v.ErrorMap)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if user.Suspended {
+		app.suspendedAccountResponse(w, r)
+		return
+	}
+
+	twoFactor, err := app.models.TOTPModel.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	hash := data.HashPlaintext(input.MFAToken)
+
+	ok, err := app.checkSecondFactor(twoFactor, input.Code, input.RecoveryCode)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !ok {
+		err = app.models.TokenModel.RecordFailedAttempt(hash, data.MFAMaxAttempts)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		app.recordLoginFailure(user)
+
+		v.Add("code", "invalid code")
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	err = app.models.TokenModel.DeleteByHash(hash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.LoginAttemptModel.Reset(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, refresh, err := app.models.TokenModel.NewSession(user.ID, app.config.tokens.accessTTL, app.config.tokens.refreshTTL, clientUserAgent(r), realip.FromRequest(r))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"authentication_token": token, "refresh_token": refresh}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
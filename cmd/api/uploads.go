@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/Arkitecth/apollo/internal/data"
+	"github.com/Arkitecth/apollo/internal/metadata"
+	"github.com/Arkitecth/apollo/internal/storage"
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/julienschmidt/httprouter"
+)
+
+const chunkContentType = "application/offset+octet-stream"
+
+func (app *application) readUploadSession(w http.ResponseWriter, r *http.Request) (*data.UploadSession, bool) {
+	id := httprouter.ParamsFromContext(r.Context()).ByName("id")
+	user := app.getUserContext(r)
+
+	session, err := app.models.UploadSessionModel.Get(id, user.ID)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return nil, false
+	}
+
+	return session, true
+}
+
+func (app *application) setUploadHeaders(w http.ResponseWriter, session *data.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	w.Header().Set("Upload-Length", strconv.FormatInt(session.Size, 10))
+	w.Header().Set("Upload-Expires", session.Expiry.UTC().Format(http.TimeFormat))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (app *application) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Filename    string `json:"filename"`
+		ContentType string `json:"content_type"`
+		Size        int64  `json:"size"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	session := &data.UploadSession{
+		Filename:    input.Filename,
+		ContentType: input.ContentType,
+		Size:        input.Size,
+	}
+
+	v := validator.New()
+
+	if data.ValidateUploadSession(v, session, app.config.uploads.maxSize); !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	user := app.getUserContext(r)
+
+	session, err = app.models.UploadSessionModel.New(user.ID, app.config.uploads.ttl, input.Filename, input.ContentType, input.Size)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	app.setUploadHeaders(w, session)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/uploads/%s", session.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"upload": session}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) showUploadHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.readUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	app.setUploadHeaders(w, session)
+	w.WriteHeader(http.StatusOK)
+}
+
+func (app *application) writeUploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Content-Type") != chunkContentType {
+		app.unsupportedMediaTypeResponse(w, r, chunkContentType)
+		return
+	}
+
+	session, ok := app.readUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		app.badRequestResponse(w, r, errors.New("Upload-Offset header must be a non-negative integer"))
+		return
+	}
+
+	if offset != session.Offset {
+		app.setUploadHeaders(w, session)
+		app.uploadOffsetConflictResponse(w, r)
+		return
+	}
+
+	// Chunks are sized for slow links, give the body longer than the server
+	// wide ReadTimeout allows.
+	err = http.NewResponseController(w).SetReadDeadline(time.Now().Add(5 * time.Minute))
+	if err != nil {
+		app.logErrors(r, err)
+	}
+
+	limit := min(session.Size-session.Offset, app.config.uploads.maxChunk)
+
+	chunk, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+	if err != nil {
+		var maxBytesError *http.MaxBytesError
+		switch {
+		case errors.As(err, &maxBytesError):
+			app.badRequestResponse(w, r, fmt.Errorf("chunk must not exceed %d bytes", maxBytesError.Limit))
+		default:
+			app.badRequestResponse(w, r, err)
+		}
+		return
+	}
+
+	if len(chunk) == 0 {
+		app.badRequestResponse(w, r, errors.New("chunk must not be empty"))
+		return
+	}
+
+	key, err := session.NewChunkKey()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.storage.Put(r.Context(), key, bytes.NewReader(chunk), int64(len(chunk)), "application/octet-stream")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	session.AddChunk(key, int64(len(chunk)))
+	session.Expiry = time.Now().Add(app.config.uploads.ttl)
+
+	// Only the request whose update wins the version check gets its chunk
+	// into the session, the others throw theirs away.
+	err = app.models.UploadSessionModel.Update(session)
+	if err != nil {
+		app.deleteObject(r.Context(), key)
+
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.uploadOffsetConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.setUploadHeaders(w, session)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (app *application) finalizeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Name   string   `json:"name"`
+		Artist string   `json:"artist"`
+		Album  string   `json:"album"`
+		Genres []string `json:"genres"`
+	}
+
+	if r.ContentLength != 0 {
+		err := app.readJSON(w, r, &input)
+		if err != nil {
+			app.badRequestResponse(w, r, err)
+			return
+		}
+	}
+
+	session, ok := app.readUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	if !session.Complete() {
+		app.setUploadHeaders(w, session)
+		app.uploadOffsetConflictResponse(w, r)
+		return
+	}
+
+	// Assembling a large file into the store takes far longer than the
+	// server wide WriteTimeout.
+	err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(10 * time.Minute))
+	if err != nil {
+		app.logErrors(r, err)
+	}
+
+	chunks := app.openUploadChunks(r.Context(), session)
+	hash, err := contentHash(chunks)
+	chunks.Close()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	claim, err := app.acquireMediaObject(hash)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	defer app.settleMediaClaim(claim)
+
+	stored := claim.object != nil
+
+	key := audioKey(hash, session.Filename)
+	if stored {
+		key = claim.object.Key
+	} else {
+		chunks := app.openUploadChunks(r.Context(), session)
+		err = app.storage.Put(r.Context(), key, chunks, session.Size, session.ContentType)
+		chunks.Close()
+		if err != nil {
+			switch {
+			case errors.Is(err, storage.ErrInvalidKey):
+				app.badRequestResponse(w, r, err)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		claim.object = &data.MediaObject{Hash: hash, Key: key, Size: session.Size}
+		claim.written = true
+	}
+
+	v := validator.New()
+
+	md, err := app.readStoredMetadata(r.Context(), key)
+	if err != nil {
+		switch {
+		case errors.Is(err, metadata.ErrUnsupportedFormat):
+			v.Add("file", "must be an mp3, flac, ogg or m4a audio file")
+		case errors.Is(err, metadata.ErrMalformed):
+			v.Add("file", "audio file is corrupt or truncated")
+		default:
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	var song *data.Song
+	var album *data.Album
+	if md != nil {
+		if !stored {
+			claim.object = app.newMediaObject(hash, key, session.Size, md)
+		}
+
+		song = app.songFromMetadata(md, session.Filename)
+		app.applySongOverrides(song, input.Name, input.Artist, input.Genres)
+		app.setSongMedia(song, claim.object)
+		album = app.songAlbum(song, input.Album, md)
+		data.ValidateSong(v, song)
+		validateSongAlbum(v, album)
+	}
+
+	// The chunks are kept on validation errors so the client can retry the
+	// finalize request with corrected fields.
+	if !v.Valid() {
+		app.failedInvalidationResponse(w, r, v.ErrorMap)
+		return
+	}
+
+	if !stored {
+		err = app.storeCoverArt(r.Context(), claim.object, md.Picture)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.setSongAlbum(song, album)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.models.SongModel.InsertWithMedia(song, claim.object)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	claim.used = true
+
+	app.creditNewSong(r, song)
+
+	app.deleteUploadSession(r.Context(), session)
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/v1/songs/%d", song.ID))
+
+	err = app.writeJSON(w, http.StatusCreated, envelope{"song": song}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteUploadHandler(w http.ResponseWriter, r *http.Request) {
+	session, ok := app.readUploadSession(w, r)
+	if !ok {
+		return
+	}
+
+	app.deleteUploadSession(r.Context(), session)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// openUploadChunks streams the chunks of a complete session back to back.
+func (app *application) openUploadChunks(ctx context.Context, session *data.UploadSession) io.ReadCloser {
+	pr, pw := io.Pipe()
+
+	go func() {
+		for _, key := range session.ChunkKeys {
+			chunk, err := app.storage.Get(ctx, key)
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+
+			_, err = io.Copy(pw, chunk)
+			chunk.Close()
+			if err != nil {
+				pw.CloseWithError(err)
+				return
+			}
+		}
+		pw.Close()
+	}()
+
+	return pr
+}
+
+func (app *application) readStoredMetadata(ctx context.Context, key string) (*metadata.Metadata, error) {
+	file, err := app.storage.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return metadata.Read(file)
+}
+
+func (app *application) deleteObject(ctx context.Context, key string) {
+	err := app.storage.Delete(ctx, key)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		app.logger.Error(err.Error(), "key", key)
+	}
+}
+
+func (app *application) deleteUploadSession(ctx context.Context, session *data.UploadSession) {
+	for _, key := range session.ChunkKeys {
+		app.deleteObject(ctx, key)
+	}
+
+	err := app.models.UploadSessionModel.Delete(session.ID)
+	if err != nil && !errors.Is(err, data.ErrRecordNotFound) {
+		app.logger.Error(err.Error(), "upload", session.ID)
+	}
+}
+
+func (app *application) expireUploadSessions() {
+	sessions, err := app.models.UploadSessionModel.GetAllExpired(100)
+	if err != nil {
+		app.logger.Error(err.Error())
+		return
+	}
+
+	for _, session := range sessions {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		app.deleteUploadSession(ctx, session)
+		cancel()
+	}
+
+	if len(sessions) > 0 {
+		app.logger.Info("expired upload sessions", "count", len(sessions))
+	}
+}
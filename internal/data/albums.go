@@ -0,0 +1,284 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateAlbum = errors.New("duplicate album")
+)
+
+type Album struct {
+	ID         int64     `json:"id"`
+	Created_At time.Time `json:"created_at"`
+	Title      string    `json:"title"`
+	Artist     string    `json:"artist"`
+	Year       int       `json:"year"`
+	Thumbnail  string    `json:"thumbnail"`
+	Version    int       `json:"version"`
+}
+
+type AlbumModel struct {
+	DB *sql.DB
+}
+
+func ValidateAlbum(v *validator.Validator, album *Album) {
+	v.Check(album.Title == "", "title", "title must be provided")
+	v.Check(len(album.Title) > 200, "title", "title cannot be greater than 200 bytes")
+	v.Check(album.Artist == "", "artist", "artist must be provided")
+	v.Check(len(album.Artist) > 200, "artist", "artist cannot be greater than 200 bytes")
+	v.Check(len(album.Thumbnail) > 500, "thumbnail", "thumbnail cannot be greater than 500 bytes")
+	v.Check(album.Year < 0, "year", "year cannot be negative")
+	v.Check(album.Year > time.Now().Year()+1, "year", "year cannot be in the future")
+}
+
+func (m AlbumModel) Insert(album *Album) error {
+	query := `INSERT INTO albums (title, artist, year, thumbnail)
+		  VALUES ($1, $2, $3, $4)
+		  RETURNING id, created_at, version`
+
+	args := []any{album.Title, album.Artist, album.Year, album.Thumbnail}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&album.ID, &album.Created_At, &album.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "albums_title_artist_key"`:
+			return ErrDuplicateAlbum
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetOrInsert returns the album matching the title and artist, ignoring case,
+// creating it when it does not exist yet. Missing year and thumbnail of an
+// existing album are filled in from the given one.
+func (m AlbumModel) GetOrInsert(album *Album) error {
+	query := `INSERT INTO albums (title, artist, year, thumbnail)
+		  VALUES ($1, $2, $3, $4)
+		  ON CONFLICT (lower(title), lower(artist)) DO UPDATE
+		  SET year = CASE WHEN albums.year = 0 THEN EXCLUDED.year ELSE albums.year END,
+		      thumbnail = CASE WHEN albums.thumbnail = '' THEN EXCLUDED.thumbnail ELSE albums.thumbnail END
+		  RETURNING id, created_at, title, artist, year, thumbnail, version`
+
+	args := []any{album.Title, album.Artist, album.Year, album.Thumbnail}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&album.ID,
+		&album.Created_At,
+		&album.Title,
+		&album.Artist,
+		&album.Year,
+		&album.Thumbnail,
+		&album.Version,
+	)
+}
+
+func (m AlbumModel) Get(id int64) (*Album, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, title, artist, year, thumbnail, version
+		  FROM albums
+		  WHERE id = $1`
+
+	album := &Album{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&album.ID,
+		&album.Created_At,
+		&album.Title,
+		&album.Artist,
+		&album.Year,
+		&album.Thumbnail,
+		&album.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return album, nil
+}
+
+func (m AlbumModel) GetAll(title string, artist string, filters Filters) ([]*Album, error) {
+	query := fmt.Sprintf(`
+	SELECT id, created_at, title, artist, year, thumbnail, version
+	FROM albums
+	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	AND (to_tsvector('simple', artist) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $3 OFFSET $4
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{title, artist, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	albums := []*Album{}
+
+	for rows.Next() {
+		var album Album
+
+		err := rows.Scan(
+			&album.ID,
+			&album.Created_At,
+			&album.Title,
+			&album.Artist,
+			&album.Year,
+			&album.Thumbnail,
+			&album.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		albums = append(albums, &album)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return albums, nil
+}
+
+// GetSongs returns the tracks of an album in disc and track order.
+func (m AlbumModel) GetSongs(albumID int64) ([]*Song, error) {
+	query := `
+	SELECT id, created_at, artist, name, song_url, thumbnail, COALESCE(album_id, 0), track_number, disc_number, year, duration, genres, version
+	FROM songs
+	WHERE album_id = $1
+	ORDER BY disc_number, track_number, id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, albumID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs := []*Song{}
+
+	for rows.Next() {
+		var song Song
+
+		err := rows.Scan(
+			&song.ID,
+			&song.Created_At,
+			&song.Artist,
+			&song.Name,
+			&song.SongURL,
+			&song.Thumbnail,
+			&song.AlbumID,
+			&song.TrackNumber,
+			&song.DiscNumber,
+			&song.Year,
+			&song.Duration,
+			pq.Array(&song.Genres),
+			&song.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		songs = append(songs, &song)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return songs, nil
+}
+
+func (m AlbumModel) Update(album *Album) error {
+	query := `UPDATE albums SET title = $1, artist = $2, year = $3, thumbnail = $4, version = version + 1
+		  WHERE id = $5 AND version = $6
+		  RETURNING version`
+
+	args := []any{
+		album.Title,
+		album.Artist,
+		album.Year,
+		album.Thumbnail,
+		album.ID,
+		album.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&album.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "albums_title_artist_key"`:
+			return ErrDuplicateAlbum
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Delete removes an album. Its songs are kept and lose their album.
+func (m AlbumModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM albums
+		  WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
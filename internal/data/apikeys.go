@@ -0,0 +1,295 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
+)
+
+// APIKeyPrefix starts every API key so a leaked key is easy to recognise in
+// logs and by secret scanners.
+const APIKeyPrefix = "apollo_pat_"
+
+var APIKeyRX = regexp.MustCompile("^" + APIKeyPrefix + "[A-Z2-7]{32}$")
+
+// APIKey is a long lived credential for scripts. A nil Permissions grants
+// everything its user may do, otherwise only the listed permissions the user
+// also holds. Plaintext is only set when the key is created.
+type APIKey struct {
+	ID          int64       `json:"id"`
+	UserID      int64       `json:"-"`
+	Name        string      `json:"name"`
+	Prefix      string      `json:"prefix"`
+	Plaintext   string      `json:"key,omitempty"`
+	Hash        []byte      `json:"-"`
+	Permissions Permissions `json:"permissions"`
+	CreatedAt   time.Time   `json:"created_at"`
+	LastUsedAt  *time.Time  `json:"last_used_at"`
+	ExpiresAt   *time.Time  `json:"expires_at"`
+}
+
+// Allows reports whether the key is scoped to include code. Whether the user
+// holds the permission is checked separately.
+func (k *APIKey) Allows(code string) bool {
+	return k.Permissions == nil || k.Permissions.Include(code)
+}
+
+type APIKeyModel struct {
+	DB *sql.DB
+}
+
+func ValidateAPIKey(v *validator.Validator, key *APIKey, granted Permissions) {
+	v.Check(key.Name == "", "name", "must be provided")
+	v.Check(len(key.Name) > 100, "name", "must not be more than 100 bytes long")
+
+	if key.Permissions != nil {
+		v.Check(len(key.Permissions) == 0, "permissions", "must contain at least one permission")
+		v.Check(!validator.Unique(key.Permissions), "permissions", "cannot contain duplicate values")
+
+		for _, code := range key.Permissions {
+			v.Check(!granted.Include(code), "permissions", "can only contain permissions you have")
+		}
+	}
+
+	if key.ExpiresAt != nil {
+		v.Check(!key.ExpiresAt.After(time.Now()), "expires_at", "must be in the future")
+	}
+}
+
+// NewAPIKey generates a key for userID. It is not stored until Insert.
+func NewAPIKey(userID int64) (*APIKey, error) {
+	randomBytes := make([]byte, 20)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext := APIKeyPrefix + base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	key := &APIKey{
+		UserID:    userID,
+		Plaintext: plaintext,
+		Prefix:    plaintext[:len(APIKeyPrefix)+6],
+		Hash:      HashPlaintext(plaintext),
+	}
+
+	return key, nil
+}
+
+func (m APIKeyModel) Insert(key *APIKey) error {
+	query := `INSERT INTO api_keys (user_id, name, prefix, hash, permissions, expires_at)
+		  VALUES ($1, $2, $3, $4, $5, $6)
+		  RETURNING id, created_at`
+
+	var permissions any
+	if key.Permissions != nil {
+		permissions = pq.Array(key.Permissions)
+	}
+
+	args := []any{key.UserID, key.Name, key.Prefix, key.Hash, permissions, key.ExpiresAt}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&key.ID, &key.CreatedAt)
+}
+
+func (m APIKeyModel) GetAllForUser(userID int64) ([]*APIKey, error) {
+	query := `SELECT id, user_id, name, prefix, permissions, created_at, last_used_at, expires_at
+		  FROM api_keys
+		  WHERE user_id = $1
+		  ORDER BY id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*APIKey{}
+
+	for rows.Next() {
+		var key APIKey
+
+		err := rows.Scan(
+			&key.ID,
+			&key.UserID,
+			&key.Name,
+			&key.Prefix,
+			pq.Array(&key.Permissions),
+			&key.CreatedAt,
+			&key.LastUsedAt,
+			&key.ExpiresAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, &key)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// Get returns one of userID's API keys.
+func (m APIKeyModel) Get(id int64, userID int64) (*APIKey, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, user_id, name, prefix, permissions, created_at, last_used_at, expires_at
+		  FROM api_keys
+		  WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var key APIKey
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID).Scan(
+		&key.ID,
+		&key.UserID,
+		&key.Name,
+		&key.Prefix,
+		pq.Array(&key.Permissions),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &key, nil
+}
+
+func (m APIKeyModel) Delete(id int64, userID int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m APIKeyModel) DeleteAllForUser(userID int64) error {
+	query := `DELETE FROM api_keys WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// GetUserFromKey returns the user an unexpired API key belongs to, together
+// with the key.
+func (m APIKeyModel) GetUserFromKey(plaintext string) (*User, *APIKey, error) {
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.suspended, users.version,
+		  api_keys.id, api_keys.name, api_keys.prefix, api_keys.hash, api_keys.permissions, api_keys.created_at, api_keys.last_used_at, api_keys.expires_at
+		  FROM api_keys
+		  INNER JOIN users ON users.id = api_keys.user_id
+		  WHERE api_keys.hash = $1
+		  AND (api_keys.expires_at IS NULL OR api_keys.expires_at > now())`
+
+	var (
+		user User
+		key  APIKey
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, HashPlaintext(plaintext)).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Suspended,
+		&user.Version,
+		&key.ID,
+		&key.Name,
+		&key.Prefix,
+		&key.Hash,
+		pq.Array(&key.Permissions),
+		&key.CreatedAt,
+		&key.LastUsedAt,
+		&key.ExpiresAt,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	key.UserID = user.ID
+
+	return &user, &key, nil
+}
+
+// TouchAll records when keys were last used, like TokenModel.TouchAll.
+func (m APIKeyModel) TouchAll(lastUsed map[string]time.Time) error {
+	if len(lastUsed) == 0 {
+		return nil
+	}
+
+	hashes := make([][]byte, 0, len(lastUsed))
+	times := make([]string, 0, len(lastUsed))
+
+	for hash, t := range lastUsed {
+		hashes = append(hashes, []byte(hash))
+		times = append(times, t.UTC().Format(time.RFC3339))
+	}
+
+	query := `UPDATE api_keys SET last_used_at = used.at
+		  FROM unnest($1::bytea[], $2::timestamptz[]) AS used(hash, at)
+		  WHERE api_keys.hash = used.hash
+		  AND (api_keys.last_used_at IS NULL OR api_keys.last_used_at < used.at)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(hashes), pq.Array(times))
+	return err
+}
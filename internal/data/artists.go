@@ -0,0 +1,407 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateArtist = errors.New("duplicate artist")
+)
+
+var ArtistRoles = []string{"primary", "featured", "composer", "remixer"}
+
+type Artist struct {
+	ID         int64     `json:"id"`
+	Created_At time.Time `json:"created_at"`
+	Name       string    `json:"name"`
+	Version    int       `json:"version"`
+}
+
+// Credit is an artist's role on a song.
+type Credit struct {
+	Artist *Artist `json:"artist"`
+	Role   string  `json:"role"`
+}
+
+// ArtistSong is a song in an artist's discography.
+type ArtistSong struct {
+	Role string `json:"role"`
+	Song *Song  `json:"song"`
+}
+
+type ArtistModel struct {
+	DB *sql.DB
+}
+
+// NormalizeArtistName folds the spellings of an artist name that should be
+// treated as the same artist, so "The Beatles" and "beatles" match. It must
+// stay in line with the backfill in the artists migration.
+func NormalizeArtistName(name string) string {
+	name = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	return strings.TrimPrefix(name, "the ")
+}
+
+func ValidateArtist(v *validator.Validator, artist *Artist) {
+	v.Check(strings.TrimSpace(artist.Name) == "", "name", "name cannot be blank")
+	v.Check(len(artist.Name) > 200, "name", "name cannot be greater than 200 bytes")
+}
+
+func ValidateArtistRole(v *validator.Validator, role string) {
+	v.Check(!validator.PermittedValue(role, ArtistRoles...), "role", "role must be one of primary, featured, composer or remixer")
+}
+
+func (m ArtistModel) Insert(artist *Artist) error {
+	query := `INSERT INTO artists (name, normalized_name)
+		  VALUES ($1, $2)
+		  RETURNING id, created_at, version`
+
+	artist.Name = strings.Join(strings.Fields(artist.Name), " ")
+	args := []any{artist.Name, NormalizeArtistName(artist.Name)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&artist.ID, &artist.Created_At, &artist.Version)
+	if err != nil {
+		switch {
+		case err.Error() == `pq: duplicate key value violates unique constraint "artists_normalized_name_key"`:
+			return ErrDuplicateArtist
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetOrInsert returns the artist whose normalized name matches name, creating
+// it when it does not exist yet.
+func (m ArtistModel) GetOrInsert(name string) (*Artist, error) {
+	query := `INSERT INTO artists (name, normalized_name)
+		  VALUES ($1, $2)
+		  ON CONFLICT (normalized_name) DO UPDATE SET normalized_name = EXCLUDED.normalized_name
+		  RETURNING id, created_at, name, version`
+
+	name = strings.Join(strings.Fields(name), " ")
+	artist := &Artist{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, name, NormalizeArtistName(name)).Scan(
+		&artist.ID,
+		&artist.Created_At,
+		&artist.Name,
+		&artist.Version,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return artist, nil
+}
+
+func (m ArtistModel) Get(id int64) (*Artist, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `SELECT id, created_at, name, version
+		  FROM artists
+		  WHERE id = $1`
+
+	artist := &Artist{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&artist.ID,
+		&artist.Created_At,
+		&artist.Name,
+		&artist.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return artist, nil
+}
+
+func (m ArtistModel) GetAll(name string, filters Filters) ([]*Artist, error) {
+	query := fmt.Sprintf(`
+	SELECT id, created_at, name, version
+	FROM artists
+	WHERE (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	artists := []*Artist{}
+
+	for rows.Next() {
+		var artist Artist
+
+		err := rows.Scan(
+			&artist.ID,
+			&artist.Created_At,
+			&artist.Name,
+			&artist.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		artists = append(artists, &artist)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return artists, nil
+}
+
+// GetSongs returns the discography of an artist, optionally limited to one
+// role, newest release first.
+func (m ArtistModel) GetSongs(artistID int64, role string, filters Filters) ([]*ArtistSong, error) {
+	query := `
+	SELECT song_artists.role, songs.id, songs.created_at, songs.artist, songs.name, songs.song_url, songs.thumbnail,
+	       COALESCE(songs.album_id, 0), songs.track_number, songs.disc_number, songs.year, songs.duration, songs.genres, songs.version
+	FROM song_artists
+	INNER JOIN songs ON songs.id = song_artists.song_id
+	WHERE song_artists.artist_id = $1
+	AND (song_artists.role = $2 OR $2 = '')
+	ORDER BY songs.year DESC, songs.album_id, songs.disc_number, songs.track_number, songs.id, song_artists.role
+	LIMIT $3 OFFSET $4`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	args := []any{artistID, role, filters.limit(), filters.offset()}
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs := []*ArtistSong{}
+
+	for rows.Next() {
+		song := ArtistSong{Song: &Song{}}
+
+		err := rows.Scan(
+			&song.Role,
+			&song.Song.ID,
+			&song.Song.Created_At,
+			&song.Song.Artist,
+			&song.Song.Name,
+			&song.Song.SongURL,
+			&song.Song.Thumbnail,
+			&song.Song.AlbumID,
+			&song.Song.TrackNumber,
+			&song.Song.DiscNumber,
+			&song.Song.Year,
+			&song.Song.Duration,
+			pq.Array(&song.Song.Genres),
+			&song.Song.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		songs = append(songs, &song)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return songs, nil
+}
+
+func (m ArtistModel) Update(artist *Artist) error {
+	query := `UPDATE artists SET name = $1, normalized_name = $2, version = version + 1
+		  WHERE id = $3 AND version = $4
+		  RETURNING version`
+
+	artist.Name = strings.Join(strings.Fields(artist.Name), " ")
+	args := []any{
+		artist.Name,
+		NormalizeArtistName(artist.Name),
+		artist.ID,
+		artist.Version,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&artist.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		case err.Error() == `pq: duplicate key value violates unique constraint "artists_normalized_name_key"`:
+			return ErrDuplicateArtist
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m ArtistModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM artists
+		  WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m ArtistModel) GetCredits(songID int64) ([]*Credit, error) {
+	query := `
+	SELECT artists.id, artists.created_at, artists.name, artists.version, song_artists.role
+	FROM song_artists
+	INNER JOIN artists ON artists.id = song_artists.artist_id
+	WHERE song_artists.song_id = $1
+	ORDER BY array_position(ARRAY['primary', 'featured', 'composer', 'remixer'], song_artists.role), artists.name`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	credits := []*Credit{}
+
+	for rows.Next() {
+		credit := Credit{Artist: &Artist{}}
+
+		err := rows.Scan(
+			&credit.Artist.ID,
+			&credit.Artist.Created_At,
+			&credit.Artist.Name,
+			&credit.Artist.Version,
+			&credit.Role,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credits = append(credits, &credit)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return credits, nil
+}
+
+// AddCredit credits an artist on a song. Adding a credit that already exists
+// is not an error.
+func (m ArtistModel) AddCredit(songID int64, artistID int64, role string) error {
+	query := `INSERT INTO song_artists (song_id, artist_id, role)
+		  VALUES ($1, $2, $3)
+		  ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, songID, artistID, role)
+	return err
+}
+
+// ReplacePrimaryCredit makes artistID the only primary artist of a song.
+func (m ArtistModel) ReplacePrimaryCredit(songID int64, artistID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM song_artists WHERE song_id = $1 AND role = 'primary'`, songID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `INSERT INTO song_artists (song_id, artist_id, role) VALUES ($1, $2, 'primary')`, songID, artistID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// RemoveCredit removes the credits of an artist on a song, every role when
+// role is empty.
+func (m ArtistModel) RemoveCredit(songID int64, artistID int64, role string) error {
+	query := `DELETE FROM song_artists
+		  WHERE song_id = $1 AND artist_id = $2 AND (role = $3 OR $3 = '')`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, songID, artistID, role)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
+)
+
+type Genre struct {
+	Name  string `json:"name"`
+	Songs int    `json:"songs"`
+}
+
+// GenreFilter limits a song listing to songs tagged with all, or any, of the
+// given genres. An empty filter matches every song.
+type GenreFilter struct {
+	Genres []string
+	Match  string
+}
+
+// NormalizeGenres lowercases and trims genres, dropping blanks and duplicates
+// so "Rock" and " rock" are stored as one tag.
+func NormalizeGenres(genres []string) []string {
+	normalized := []string{}
+	seen := make(map[string]bool)
+
+	for _, genre := range genres {
+		genre = strings.ToLower(strings.Join(strings.Fields(genre), " "))
+		if genre == "" || seen[genre] {
+			continue
+		}
+
+		seen[genre] = true
+		normalized = append(normalized, genre)
+	}
+
+	return normalized
+}
+
+func ValidateGenres(v *validator.Validator, genres []string) {
+	v.Check(len(genres) > 10, "genres", "cannot contain more than 10 genres")
+	v.Check(!validator.Unique(genres), "genres", "cannot contain duplicate values")
+
+	for _, genre := range genres {
+		v.Check(genre == "", "genres", "cannot contain blank genres")
+		v.Check(len(genre) > 50, "genres", "genres cannot be greater than 50 bytes")
+	}
+}
+
+func ValidateGenreFilter(v *validator.Validator, f GenreFilter) {
+	ValidateGenres(v, f.Genres)
+	v.Check(!validator.PermittedValue(f.Match, "all", "any"), "genres_match", "must be all or any")
+}
+
+func (f GenreFilter) args() []any {
+	return []any{pq.Array(f.Genres), f.Match}
+}
+
+// GetGenres returns every genre in use with the number of songs tagged with it.
+func (m *SongModel) GetGenres() ([]*Genre, error) {
+	query := `SELECT genre, count(*)
+		  FROM songs, unnest(genres) AS genre
+		  GROUP BY genre
+		  ORDER BY genre`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	genres := []*Genre{}
+
+	for rows.Next() {
+		var genre Genre
+
+		err := rows.Scan(&genre.Name, &genre.Songs)
+		if err != nil {
+			return nil, err
+		}
+
+		genres = append(genres, &genre)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return genres, nil
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Identity links a user to an account at an OpenID Connect provider, which
+// is known by its issuer and the subject it gives the user.
+type Identity struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"-"`
+	Issuer    string    `json:"issuer"`
+	Subject   string    `json:"subject"`
+	Email     string    `json:"email"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LoginState is what a login at the identity provider is checked against
+// when it returns to the callback.
+type LoginState struct {
+	Nonce        string
+	CodeVerifier string
+}
+
+type IdentityModel struct {
+	DB *sql.DB
+}
+
+// InsertLoginState stores a login that was just started under the hash of
+// its state parameter, clearing out logins that were never finished.
+func (m IdentityModel) InsertLoginState(state string, login *LoginState, ttl time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `DELETE FROM oidc_login_states WHERE expiry < now()`)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO oidc_login_states (state_hash, nonce, code_verifier, expiry)
+		  VALUES ($1, $2, $3, $4)`
+
+	args := []any{HashPlaintext(state), login.Nonce, login.CodeVerifier, time.Now().Add(ttl)}
+
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// ConsumeLoginState returns and deletes the login started with state, so a
+// callback cannot be replayed.
+func (m IdentityModel) ConsumeLoginState(state string) (*LoginState, error) {
+	query := `DELETE FROM oidc_login_states
+		  WHERE state_hash = $1
+		  RETURNING nonce, code_verifier, expiry`
+
+	var (
+		login  LoginState
+		expiry time.Time
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, HashPlaintext(state)).Scan(&login.Nonce, &login.CodeVerifier, &expiry)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if time.Now().After(expiry) {
+		return nil, ErrRecordNotFound
+	}
+
+	return &login, nil
+}
+
+// GetUser returns the user linked to the identity.
+func (m IdentityModel) GetUser(issuer string, subject string) (*User, error) {
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.suspended, users.version
+		  FROM users
+		  INNER JOIN user_identities ON user_identities.user_id = users.id
+		  WHERE user_identities.issuer = $1
+		  AND user_identities.subject = $2`
+
+	var user User
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, issuer, subject).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Suspended,
+		&user.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &user, nil
+}
+
+// Link connects an identity to a user. Linking an identity that is already
+// linked does nothing.
+func (m IdentityModel) Link(identity *Identity) error {
+	query := `INSERT INTO user_identities (user_id, issuer, subject, email)
+		  VALUES ($1, $2, $3, $4)
+		  ON CONFLICT (issuer, subject) DO NOTHING`
+
+	args := []any{identity.UserID, identity.Issuer, identity.Subject, identity.Email}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
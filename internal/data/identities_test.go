@@ -0,0 +1,73 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// openTestDB connects to the migrated database named by APOLLO_TEST_DB_DSN,
+// skipping the test when it is not set.
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+
+	dsn := os.Getenv("APOLLO_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("APOLLO_TEST_DB_DSN is not set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	err = db.Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return db
+}
+
+func TestConsumeLoginStateOnce(t *testing.T) {
+	m := IdentityModel{DB: openTestDB(t)}
+
+	login := &LoginState{Nonce: "nonce", CodeVerifier: "verifier"}
+
+	err := m.InsertLoginState("replayed-state", login, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := m.ConsumeLoginState("replayed-state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != *login {
+		t.Errorf("login = %+v; want %+v", *got, *login)
+	}
+
+	_, err = m.ConsumeLoginState("replayed-state")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("replayed state: err = %v; want %v", err, ErrRecordNotFound)
+	}
+}
+
+func TestConsumeLoginStateExpired(t *testing.T) {
+	m := IdentityModel{DB: openTestDB(t)}
+
+	err := m.InsertLoginState("expired-state", &LoginState{Nonce: "nonce", CodeVerifier: "verifier"}, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.ConsumeLoginState("expired-state")
+	if !errors.Is(err, ErrRecordNotFound) {
+		t.Errorf("err = %v; want %v", err, ErrRecordNotFound)
+	}
+}
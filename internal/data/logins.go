@@ -0,0 +1,78 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Lockout is the back-off applied to an account after failed logins: once
+// MaxAttempts passwords in a row were wrong the account is locked for
+// Duration, doubling with every further failure up to MaxDuration.
+type Lockout struct {
+	MaxAttempts int
+	Duration    time.Duration
+	MaxDuration time.Duration
+}
+
+// LoginAttempts is the count of failed logins since the last successful one.
+type LoginAttempts struct {
+	Failed      int
+	LockedUntil *time.Time
+}
+
+// Locked reports whether the account is locked at t.
+func (a *LoginAttempts) Locked(t time.Time) bool {
+	return a.LockedUntil != nil && t.Before(*a.LockedUntil)
+}
+
+type LoginAttemptModel struct {
+	DB *sql.DB
+}
+
+// RecordFailure counts a wrong password and locks the account when lockout
+// says so. The count is incremented in the database so concurrent attempts
+// cannot slip past the limit.
+func (m LoginAttemptModel) RecordFailure(userID int64, lockout Lockout) (*LoginAttempts, error) {
+	query := `UPDATE users
+		  SET failed_logins = failed_logins + 1,
+		  locked_until = CASE
+			WHEN failed_logins + 1 >= $2
+			THEN now() + make_interval(secs => LEAST($3 * power(2, LEAST(failed_logins + 1 - $2, 30)), $4))
+			ELSE locked_until
+		  END
+		  WHERE id = $1
+		  RETURNING failed_logins, locked_until`
+
+	args := []any{userID, lockout.MaxAttempts, lockout.Duration.Seconds(), lockout.MaxDuration.Seconds()}
+
+	var attempts LoginAttempts
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&attempts.Failed, &attempts.LockedUntil)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &attempts, nil
+}
+
+// Reset clears the failed logins of an account after a successful one.
+func (m LoginAttemptModel) Reset(userID int64) error {
+	query := `UPDATE users SET failed_logins = 0, locked_until = NULL
+		  WHERE id = $1 AND (failed_logins > 0 OR locked_until IS NOT NULL)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
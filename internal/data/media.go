@@ -0,0 +1,164 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/Arkitecth/apollo/validator"
+)
+
+type MediaObject struct {
+	ID          int64     `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	Hash        string    `json:"hash"`
+	Key         string    `json:"-"`
+	Size        int64     `json:"size"`
+	ContentType string    `json:"content_type"`
+	CoverKey    string    `json:"-"`
+	RefCount    int       `json:"ref_count"`
+}
+
+type MediaObjectModel struct {
+	DB *sql.DB
+}
+
+func ValidateContentHash(v *validator.Validator, hash string) {
+	v.Check(!validator.Matches(hash, validator.SHA256RX), "content_hash", "must be a hex encoded sha-256 hash")
+}
+
+// Acquire takes a reference on the media object stored for hash. Holding
+// it keeps the object from being swept while a song is created for it; it
+// has to be released again if no song ends up using the object.
+func (m MediaObjectModel) Acquire(hash string) (*MediaObject, error) {
+	query := `UPDATE media_objects SET ref_count = ref_count + 1, released_at = NULL
+		  WHERE hash = $1
+		  RETURNING id, created_at, hash, key, size, content_type, cover_key, ref_count`
+
+	var object MediaObject
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, hash).Scan(
+		&object.ID,
+		&object.CreatedAt,
+		&object.Hash,
+		&object.Key,
+		&object.Size,
+		&object.ContentType,
+		&object.CoverKey,
+		&object.RefCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &object, nil
+}
+
+// releaseQuery drops one reference to a media object. An object nobody
+// references is kept until DeleteReleased sweeps it.
+const releaseQuery = `UPDATE media_objects
+	  SET ref_count = ref_count - 1,
+	  released_at = CASE WHEN ref_count <= 1 THEN now() ELSE NULL END
+	  WHERE id = $1`
+
+// Release gives back a reference taken with Acquire.
+func (m MediaObjectModel) Release(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, releaseQuery, id)
+	return err
+}
+
+func (m MediaObjectModel) release(ctx context.Context, tx *sql.Tx, id int64) error {
+	_, err := tx.ExecContext(ctx, releaseQuery, id)
+	return err
+}
+
+// InsertReleased registers stored blobs no song ended up using, so that
+// DeleteReleased removes them. Nothing is done when the hash is registered
+// already.
+func (m MediaObjectModel) InsertReleased(object *MediaObject) error {
+	query := `INSERT INTO media_objects (hash, key, size, content_type, cover_key, ref_count, released_at)
+		  VALUES ($1, $2, $3, $4, $5, 0, now())
+		  ON CONFLICT (hash) DO NOTHING`
+
+	args := []any{object.Hash, object.Key, object.Size, object.ContentType, object.CoverKey}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, args...)
+	return err
+}
+
+// DeleteReleased removes one media object that nobody has referenced since
+// before, calling remove to delete its blobs first. The row stays locked
+// while remove runs, so an upload of the same file waits in Acquire instead
+// of taking a reference on blobs that are going away. It returns nil once
+// there is nothing left to remove.
+func (m MediaObjectModel) DeleteReleased(before time.Time, remove func(*MediaObject) error) (*MediaObject, error) {
+	query := `SELECT id, created_at, hash, key, size, content_type, cover_key, ref_count
+		  FROM media_objects
+		  WHERE ref_count = 0 AND released_at < $1
+		  ORDER BY released_at
+		  LIMIT 1
+		  FOR UPDATE SKIP LOCKED`
+
+	// Deleting blobs from remote storage can take longer than a query.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var object MediaObject
+
+	err = tx.QueryRowContext(ctx, query, before).Scan(
+		&object.ID,
+		&object.CreatedAt,
+		&object.Hash,
+		&object.Key,
+		&object.Size,
+		&object.ContentType,
+		&object.CoverKey,
+		&object.RefCount,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	err = remove(&object)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM media_objects WHERE id = $1`, object.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+
+	return &object, nil
+}
@@ -6,16 +6,27 @@ import (
 )
 
 var (
-	ErrRecordNotFound = errors.New("no record found")
-	ErrEditConflict   = errors.New("edit conflict")
+	ErrRecordNotFound  = errors.New("no record found")
+	ErrEditConflict    = errors.New("edit conflict")
+	ErrInvalidPosition = errors.New("invalid position")
+	ErrNotPermitted    = errors.New("not permitted")
 )
 
 type Model struct {
-	SongModel       SongModel
-	PlaylistModel   PlaylistModel
-	UserModel       UserModel
-	TokenModel      TokenModel
-	PermissionModel PermissionModel
+	SongModel          SongModel
+	PlaylistModel      PlaylistModel
+	UserModel          UserModel
+	LoginAttemptModel  LoginAttemptModel
+	TokenModel         TokenModel
+	PermissionModel    PermissionModel
+	RoleModel          RoleModel
+	UploadSessionModel UploadSessionModel
+	MediaObjectModel   MediaObjectModel
+	AlbumModel         AlbumModel
+	ArtistModel        ArtistModel
+	APIKeyModel        APIKeyModel
+	TOTPModel          TOTPModel
+	IdentityModel      IdentityModel
 }
 
 func NewModel(db *sql.DB) Model {
@@ -30,6 +41,10 @@ func NewModel(db *sql.DB) Model {
 			DB: db,
 		},
 
+		LoginAttemptModel: LoginAttemptModel{
+			DB: db,
+		},
+
 		TokenModel: TokenModel{
 			DB: db,
 		},
@@ -37,5 +52,37 @@ func NewModel(db *sql.DB) Model {
 		PermissionModel: PermissionModel{
 			DB: db,
 		},
+
+		RoleModel: RoleModel{
+			DB: db,
+		},
+
+		UploadSessionModel: UploadSessionModel{
+			DB: db,
+		},
+
+		MediaObjectModel: MediaObjectModel{
+			DB: db,
+		},
+
+		AlbumModel: AlbumModel{
+			DB: db,
+		},
+
+		ArtistModel: ArtistModel{
+			DB: db,
+		},
+
+		APIKeyModel: APIKeyModel{
+			DB: db,
+		},
+
+		TOTPModel: TOTPModel{
+			DB: db,
+		},
+
+		IdentityModel: IdentityModel{
+			DB: db,
+		},
 	}
 }
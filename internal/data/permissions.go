@@ -19,12 +19,19 @@ type PermissionModel struct {
 	DB *sql.DB
 }
 
+// GetAllForUser returns the permissions granted to the user directly
+// together with those of the user's roles.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	query := `SELECT permissions.code
 		  FROM permissions
-		  INNER JOIN users_permissions on users_permissions.permission_id = permissions.id
-		  INNER JOIN users ON users_permissions.user_id = users.id
-		  WHERE users.id = $1
+		  INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
+		  WHERE users_permissions.user_id = $1
+		  UNION
+		  SELECT permissions.code
+		  FROM permissions
+		  INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+		  INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+		  WHERE users_roles.user_id = $1
 		 `
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -55,7 +62,25 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 
 func (m PermissionModel) AddForUsers(userID int64, code ...string) error {
 	query := `INSERT INTO users_permissions
-		  SELECT $1, permissions.id from permissions where code = ANY($2)`
+		  SELECT $1, permissions.id from permissions where code = ANY($2)
+		  ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(code))
+
+	return err
+}
+
+// RemoveForUsers takes permissions granted directly away from the user.
+// Permissions the user holds through a role stay.
+func (m PermissionModel) RemoveForUsers(userID int64, code ...string) error {
+	query := `DELETE FROM users_permissions
+		  USING permissions
+		  WHERE users_permissions.permission_id = permissions.id
+		  AND users_permissions.user_id = $1
+		  AND permissions.code = ANY($2)`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -64,3 +89,33 @@ func (m PermissionModel) AddForUsers(userID int64, code ...string) error {
 
 	return err
 }
+
+// GetAll returns every permission code there is.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `SELECT code FROM permissions ORDER BY code`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var permissions Permissions
+
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
+}
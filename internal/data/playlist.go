@@ -8,14 +8,64 @@ import (
 	"time"
 
 	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
 )
 
+const (
+	PlaylistOwner  = "owner"
+	PlaylistEditor = "editor"
+	PlaylistViewer = "viewer"
+)
+
+var PlaylistVisibilities = []string{"private", "unlisted", "public"}
+
 type Playlist struct {
-	ID         int64     `json:"id"`
-	Created_At time.Time `json:"created_at"`
-	Name       string    `json:"name"`
+	ID         int64       `json:"id"`
+	Created_At time.Time   `json:"created_at"`
+	Name       string      `json:"name"`
+	UserID     int64       `json:"user_id"`
+	Visibility string      `json:"visibility"`
+	Role       string      `json:"role,omitempty"`
+	Rules      *SmartRules `json:"rules,omitempty"`
+	Version    int         `json:"version"`
+}
+
+// Allows reports whether the role the playlist was loaded with grants at
+// least the given role. Anyone who can load a playlist may view it.
+func (p *Playlist) Allows(role string) bool {
+	rank := map[string]int{PlaylistViewer: 1, PlaylistEditor: 2, PlaylistOwner: 3}
+
+	have := rank[p.Role]
+	if have == 0 {
+		have = rank[PlaylistViewer]
+	}
+
+	return have >= rank[role]
+}
+
+// Shared reports whether the playlist was loaded by its owner or one of its
+// members, rather than by someone who can only see it because it is not
+// private.
+func (p *Playlist) Shared() bool {
+	return p.Role != ""
+}
+
+type PlaylistMember struct {
 	UserID     int64     `json:"user_id"`
-	Version    int       `json:"version"`
+	Name       string    `json:"name"`
+	Role       string    `json:"role"`
+	Created_At time.Time `json:"created_at"`
+}
+
+// PlaylistSong is a song together with its 1-based position in a playlist.
+type PlaylistSong struct {
+	Position int `json:"position"`
+	*Song
+}
+
+type PlaylistMove struct {
+	From int `json:"from"`
+	To   int `json:"to"`
 }
 
 type PlaylistModel struct {
@@ -23,14 +73,36 @@ type PlaylistModel struct {
 }
 
 func ValidateName(v *validator.Validator, name string) {
+	v.Check(name == "", "name", "must be provided")
 	v.Check(len(name) > 50, "name", "cannot be greater than 50 bytes")
 }
 
+func ValidateVisibility(v *validator.Validator, visibility string) {
+	v.Check(!validator.PermittedValue(visibility, PlaylistVisibilities...), "visibility", "must be private, unlisted or public")
+}
+
+func ValidateMemberRole(v *validator.Validator, role string) {
+	v.Check(!validator.PermittedValue(role, PlaylistViewer, PlaylistEditor), "role", "must be viewer or editor")
+}
+
+func ValidatePosition(v *validator.Validator, position int) {
+	v.Check(position < 0, "position", "must be a positive integer")
+}
+
+func ValidatePlaylistMoves(v *validator.Validator, moves []PlaylistMove) {
+	v.Check(len(moves) == 0, "moves", "must contain at least one move")
+	v.Check(len(moves) > 100, "moves", "must not contain more than 100 moves")
+
+	for _, move := range moves {
+		v.Check(move.From < 1 || move.To < 1, "moves", "positions must be positive integers")
+	}
+}
+
 func (m *PlaylistModel) Insert(playlist *Playlist) error {
-	query := `INSERT INTO playlists (name, user_id) 
-		  VALUES ($1, $2)
+	query := `INSERT INTO playlists (name, user_id, visibility, rules) 
+		  VALUES ($1, $2, $3, $4)
 		  RETURNING id, created_at, version`
-	args := []any{playlist.Name, playlist.UserID}
+	args := []any{playlist.Name, playlist.UserID, playlist.Visibility, playlist.Rules}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
@@ -44,26 +116,39 @@ func (m *PlaylistModel) Insert(playlist *Playlist) error {
 			return err
 		}
 	}
+	playlist.Role = PlaylistOwner
 	return nil
 }
 
-func (m *PlaylistModel) Get(playlistID int64) (*Playlist, error) {
+// Get returns a playlist userID can see: one they own or are a member of, or
+// one that is not private. Role is set to the user's role on the playlist.
+// Other playlists are reported as not found so their existence is not
+// revealed.
+func (m *PlaylistModel) Get(playlistID int64, userID int64) (*Playlist, error) {
 	if playlistID < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT id, created_at, name, user_id, version FROM playlists
-		  WHERE id = $1 `
+	query := `SELECT playlists.id, playlists.created_at, playlists.name, playlists.user_id, playlists.visibility,
+		  CASE WHEN playlists.user_id = $2 THEN 'owner' ELSE COALESCE(playlist_members.role, '') END,
+		  playlists.rules, playlists.version
+		  FROM playlists
+		  LEFT JOIN playlist_members ON playlist_members.playlist_id = playlists.id AND playlist_members.user_id = $2
+		  WHERE playlists.id = $1
+		  AND (playlists.user_id = $2 OR playlist_members.user_id IS NOT NULL OR playlists.visibility <> 'private')`
 
 	playlist := &Playlist{}
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, playlistID).Scan(
+	err := m.DB.QueryRowContext(ctx, query, playlistID, userID).Scan(
 		&playlist.ID,
 		&playlist.Created_At,
 		&playlist.Name,
 		&playlist.UserID,
+		&playlist.Visibility,
+		&playlist.Role,
+		&playlist.Rules,
 		&playlist.Version,
 	)
 	if err != nil {
@@ -78,33 +163,237 @@ func (m *PlaylistModel) Get(playlistID int64) (*Playlist, error) {
 
 }
 
-func (m *PlaylistModel) InsertSong(songID int64, playlistID int64) error {
-	query := `INSERT INTO playlist_songs (song_id, playlist_id)
-		  VALUES ($1, $2)`
-
+// InsertWithSongs creates a playlist already holding songIDs in the given
+// order, added by the playlist owner.
+func (m *PlaylistModel) InsertWithSongs(playlist *Playlist, songIDs []int64) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	_, err := m.DB.ExecContext(ctx, query, songID, playlistID)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO playlists (name, user_id, visibility) 
+		  VALUES ($1, $2, $3)
+		  RETURNING id, created_at, version`
+
+	err = tx.QueryRowContext(ctx, query, playlist.Name, playlist.UserID, playlist.Visibility).Scan(&playlist.ID, &playlist.Created_At, &playlist.Version)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO playlist_songs (song_id, playlist_id, user_id, position)
+		  SELECT song_id, $2, $3, position
+		  FROM unnest($1::bigint[]) WITH ORDINALITY AS imported(song_id, position)`
+
+	_, err = tx.ExecContext(ctx, query, pq.Array(songIDs), playlist.ID, playlist.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Commit()
 	if err != nil {
 		return err
 	}
+
+	playlist.Role = PlaylistOwner
 	return nil
 }
 
+// InsertSong adds a song to a playlist at a 1-based position, shifting the
+// songs at and after it down. A position of zero, or one past the end,
+// appends the song. The position the song ended up at is returned. userID
+// must own the playlist or be one of its editors.
+func (m *PlaylistModel) InsertSong(songID int64, playlistID int64, userID int64, position int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	count, err := m.lockSongs(ctx, tx, playlistID, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if position == 0 {
+		position = count + 1
+	}
+	if position < 1 || position > count+1 {
+		return 0, ErrInvalidPosition
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE playlist_songs SET position = position + 1
+		  WHERE playlist_id = $1 AND position >= $2`, playlistID, position)
+	if err != nil {
+		return 0, err
+	}
+
+	query := `INSERT INTO playlist_songs (song_id, playlist_id, user_id, position)
+		  VALUES ($1, $2, $3, $4)`
+
+	_, err = tx.ExecContext(ctx, query, songID, playlistID, userID, position)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return position, nil
+}
+
+// MoveSongs applies the moves in order, each taking the song at From and
+// placing it at To. Either all moves are applied or none are. userID must
+// own the playlist or be one of its editors.
+func (m *PlaylistModel) MoveSongs(playlistID int64, userID int64, moves []PlaylistMove) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	count, err := m.lockSongs(ctx, tx, playlistID, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, move := range moves {
+		if move.From < 1 || move.From > count || move.To < 1 || move.To > count {
+			return ErrInvalidPosition
+		}
+
+		var query string
+		switch {
+		case move.From < move.To:
+			query = `UPDATE playlist_songs
+			  SET position = CASE WHEN position = $2 THEN $3 ELSE position - 1 END
+			  WHERE playlist_id = $1 AND position BETWEEN $2 AND $3`
+		case move.From > move.To:
+			query = `UPDATE playlist_songs
+			  SET position = CASE WHEN position = $2 THEN $3 ELSE position + 1 END
+			  WHERE playlist_id = $1 AND position BETWEEN $3 AND $2`
+		default:
+			continue
+		}
+
+		_, err = tx.ExecContext(ctx, query, playlistID, move.From, move.To)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// lockSongs serializes changes to the order of a playlist and returns the
+// number of songs in it. The role check happens under the same lock, so a
+// member whose role is revoked concurrently cannot slip a change in. It
+// returns ErrNotPermitted when userID is neither the owner nor an editor.
+func (m *PlaylistModel) lockSongs(ctx context.Context, tx *sql.Tx, playlistID int64, userID int64) (int, error) {
+	query := `SELECT user_id = $2 OR EXISTS (
+			SELECT 1 FROM playlist_members
+			WHERE playlist_members.playlist_id = playlists.id AND playlist_members.user_id = $2 AND role = 'editor'
+		  )
+		  FROM playlists
+		  WHERE id = $1
+		  FOR UPDATE`
+
+	var permitted bool
+
+	err := tx.QueryRowContext(ctx, query, playlistID, userID).Scan(&permitted)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return 0, ErrRecordNotFound
+		default:
+			return 0, err
+		}
+	}
+
+	if !permitted {
+		return 0, ErrNotPermitted
+	}
+
+	var count int
+
+	err = tx.QueryRowContext(ctx, `SELECT count(*) FROM playlist_songs WHERE playlist_id = $1`, playlistID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// GetAll returns the playlists userID owns or is a member of.
 func (m *PlaylistModel) GetAll(userID int64) ([]*Playlist, error) {
-	query := `SELECT id, created_at, name FROM playlists 
-		  WHERE user_id = $1`
+	query := `SELECT playlists.id, playlists.created_at, playlists.name, playlists.user_id, playlists.visibility,
+		  CASE WHEN playlists.user_id = $1 THEN 'owner' ELSE COALESCE(playlist_members.role, '') END,
+		  playlists.rules, playlists.version
+		  FROM playlists 
+		  LEFT JOIN playlist_members ON playlist_members.playlist_id = playlists.id AND playlist_members.user_id = $1
+		  WHERE playlists.user_id = $1 OR playlist_members.user_id IS NOT NULL
+		  ORDER BY playlists.id`
+
+	playlists := []*Playlist{}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var playlist Playlist
+		err := rows.Scan(&playlist.ID, &playlist.Created_At, &playlist.Name, &playlist.UserID, &playlist.Visibility, &playlist.Role, &playlist.Rules, &playlist.Version)
+		if err != nil {
+			return nil, err
+		}
+		playlists = append(playlists, &playlist)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return playlists, nil
+}
+
+// GetAllPublic returns the playlists anyone can discover. Unlisted playlists
+// are left out, they can only be opened by id.
+func (m *PlaylistModel) GetAllPublic(name string, filters Filters) ([]*Playlist, error) {
+	query := fmt.Sprintf(`SELECT id, created_at, name, user_id, visibility, rules, version FROM playlists
+		  WHERE visibility = 'public'
+		  AND (to_tsvector('simple', name) @@ plainto_tsquery('simple', $1) OR $1 = '')
+		  ORDER BY %s %s, id ASC
+		  LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
 
 	playlists := []*Playlist{}
 
-	rows, err := m.DB.Query(query, userID)
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, name, filters.limit(), filters.offset())
 	if err != nil {
 		return nil, err
 	}
+	defer rows.Close()
+
 	for rows.Next() {
 		var playlist Playlist
-		err := rows.Scan(&playlist.ID, &playlist.Created_At, &playlist.Name)
+		err := rows.Scan(&playlist.ID, &playlist.Created_At, &playlist.Name, &playlist.UserID, &playlist.Visibility, &playlist.Rules, &playlist.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -118,16 +407,20 @@ func (m *PlaylistModel) GetAll(userID int64) ([]*Playlist, error) {
 }
 
 func (m *PlaylistModel) Update(playlist *Playlist) error {
-	query := `UPDATE playlists SET name = $1, version = version + 1
-		  WHERE id = $2 AND version = $3`
+	query := `UPDATE playlists SET name = $1, visibility = $2, rules = $3, version = version + 1
+		  WHERE id = $4 AND version = $5 AND user_id = $6
+		  RETURNING version`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	args := []any{
-		&playlist.Name,
-		&playlist.ID,
-		&playlist.Version,
+		playlist.Name,
+		playlist.Visibility,
+		playlist.Rules,
+		playlist.ID,
+		playlist.Version,
+		playlist.UserID,
 	}
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&playlist.Version)
@@ -143,17 +436,17 @@ func (m *PlaylistModel) Update(playlist *Playlist) error {
 	return nil
 }
 
-func (m *PlaylistModel) Delete(id int64) error {
+func (m *PlaylistModel) Delete(id int64, userID int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `DELETE FROM playlists
-		  WHERE id = $1 `
+		  WHERE id = $1 AND user_id = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, id, userID)
 	if err != nil {
 		return err
 	}
@@ -170,13 +463,27 @@ func (m *PlaylistModel) Delete(id int64) error {
 	return nil
 }
 
-func (m *PlaylistModel) DeleteSongFromPlaylist(songID int64, playlistID int64) error {
+// DeleteSongFromPlaylist removes a song from a playlist and closes the gap
+// it leaves in the order. userID must own the playlist or be one of its
+// editors.
+func (m *PlaylistModel) DeleteSongFromPlaylist(songID int64, playlistID int64, userID int64) error {
 	query := `DELETE FROM playlist_songs WHERE song_id = $1 AND playlist_id = $2`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, songID, playlistID)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = m.lockSongs(ctx, tx, playlistID, userID)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, query, songID, playlistID)
 	if err != nil {
 		return err
 	}
@@ -190,25 +497,44 @@ func (m *PlaylistModel) DeleteSongFromPlaylist(songID int64, playlistID int64) e
 		return ErrRecordNotFound
 	}
 
-	return nil
+	_, err = tx.ExecContext(ctx, `UPDATE playlist_songs SET position = ranked.position
+		  FROM (
+			SELECT id, row_number() OVER (ORDER BY position) AS position
+			FROM playlist_songs
+			WHERE playlist_id = $1
+		  ) AS ranked
+		  WHERE playlist_songs.id = ranked.id AND playlist_songs.position <> ranked.position`, playlistID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
 }
 
-func (m *PlaylistModel) GetSongsFromPlaylist(playlistID int64, artist string, name string, filters Filters) ([]*Song, error) {
+func (m *PlaylistModel) GetSongsFromPlaylist(playlistID int64, artist string, name string, genres GenreFilter, filters Filters) ([]*PlaylistSong, error) {
+	sortColumn := "songs." + filters.sortColumn()
+	if filters.sortColumn() == "position" {
+		sortColumn = "playlist_songs.position"
+	}
+
 	query := fmt.Sprintf(`
-	SELECT songs.id, songs.created_at, songs.artist, songs.name, songs.song_url, songs.thumbnail, songs.version
+	SELECT playlist_songs.position, songs.id, songs.created_at, songs.artist, songs.name, songs.song_url, songs.thumbnail,
+	COALESCE(songs.album_id, 0), songs.track_number, songs.disc_number, songs.year, songs.duration, songs.genres, songs.version
 	FROM songs 
 	INNER JOIN playlist_songs ON song_id = songs.id
 	WHERE playlist_songs.playlist_id = $1
 	AND (to_tsvector('simple', artist) @@ plainto_tsquery('simple', $2) OR $2 = '') 
 	AND (to_tsvector('simple', name) @@ plainto_tsquery('simple', $3) OR $3 = '')
-	ORDER BY %s %s, id ASC
-	LIMIT $4 OFFSET $5
-	`, filters.sortColumn(), filters.sortDirection())
+	AND (cardinality($4::text[]) = 0 OR ($5 = 'all' AND songs.genres @> $4) OR ($5 = 'any' AND songs.genres && $4))
+	ORDER BY %s %s, playlist_songs.position ASC
+	LIMIT $6 OFFSET $7
+	`, sortColumn, filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{playlistID, artist, name, filters.limit(), filters.offset()}
+	args := append([]any{playlistID, artist, name}, genres.args()...)
+	args = append(args, filters.limit(), filters.offset())
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -217,18 +543,25 @@ func (m *PlaylistModel) GetSongsFromPlaylist(playlistID int64, artist string, na
 
 	defer rows.Close()
 
-	songs := []*Song{}
+	songs := []*PlaylistSong{}
 
 	for rows.Next() {
-		var song Song
+		song := PlaylistSong{Song: &Song{}}
 
 		err := rows.Scan(
+			&song.Position,
 			&song.ID,
 			&song.Created_At,
 			&song.Artist,
 			&song.Name,
 			&song.SongURL,
 			&song.Thumbnail,
+			&song.AlbumID,
+			&song.TrackNumber,
+			&song.DiscNumber,
+			&song.Year,
+			&song.Duration,
+			pq.Array(&song.Genres),
 			&song.Version,
 		)
 
@@ -244,3 +577,75 @@ func (m *PlaylistModel) GetSongsFromPlaylist(playlistID int64, artist string, na
 
 	return songs, nil
 }
+
+// AddMember shares a playlist with a user, changing the role of an existing
+// member.
+func (m *PlaylistModel) AddMember(playlistID int64, userID int64, role string) error {
+	query := `INSERT INTO playlist_members (playlist_id, user_id, role)
+		  VALUES ($1, $2, $3)
+		  ON CONFLICT (playlist_id, user_id) DO UPDATE SET role = EXCLUDED.role`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, playlistID, userID, role)
+	return err
+}
+
+func (m *PlaylistModel) RemoveMember(playlistID int64, userID int64) error {
+	query := `DELETE FROM playlist_members WHERE playlist_id = $1 AND user_id = $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, playlistID, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m *PlaylistModel) GetMembers(playlistID int64) ([]*PlaylistMember, error) {
+	query := `SELECT users.id, users.name, playlist_members.role, playlist_members.created_at
+		  FROM playlist_members
+		  INNER JOIN users ON users.id = playlist_members.user_id
+		  WHERE playlist_members.playlist_id = $1
+		  ORDER BY playlist_members.created_at, users.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, playlistID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []*PlaylistMember{}
+
+	for rows.Next() {
+		var member PlaylistMember
+
+		err := rows.Scan(&member.UserID, &member.Name, &member.Role, &member.Created_At)
+		if err != nil {
+			return nil, err
+		}
+
+		members = append(members, &member)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
@@ -0,0 +1,128 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// The roles seeded by the migrations. Each bundles permission codes:
+// listeners stream, uploaders add songs, curators edit the catalogue and
+// admins hold every permission.
+const (
+	RoleListener = "listener"
+	RoleUploader = "uploader"
+	RoleCurator  = "curator"
+	RoleAdmin    = "admin"
+)
+
+type Role struct {
+	Name        string      `json:"name"`
+	Permissions Permissions `json:"permissions"`
+}
+
+type RoleModel struct {
+	DB *sql.DB
+}
+
+// GetAll returns every role with the permissions it grants.
+func (m RoleModel) GetAll() ([]*Role, error) {
+	query := `SELECT roles.name, COALESCE(array_agg(permissions.code ORDER BY permissions.code) FILTER (WHERE permissions.code IS NOT NULL), '{}')
+		  FROM roles
+		  LEFT JOIN roles_permissions ON roles_permissions.role_id = roles.id
+		  LEFT JOIN permissions ON permissions.id = roles_permissions.permission_id
+		  GROUP BY roles.id
+		  ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []*Role{}
+
+	for rows.Next() {
+		var role Role
+
+		err := rows.Scan(&role.Name, pq.Array(&role.Permissions))
+		if err != nil {
+			return nil, err
+		}
+
+		roles = append(roles, &role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (m RoleModel) GetAllForUser(userID int64) ([]string, error) {
+	query := `SELECT roles.name
+		  FROM roles
+		  INNER JOIN users_roles ON users_roles.role_id = roles.id
+		  WHERE users_roles.user_id = $1
+		  ORDER BY roles.id`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+
+	for rows.Next() {
+		var role string
+		err := rows.Scan(&role)
+		if err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+// AddForUsers gives the user the named roles. Roles the user already has,
+// and names that are not roles, are skipped.
+func (m RoleModel) AddForUsers(userID int64, roles ...string) error {
+	query := `INSERT INTO users_roles
+		  SELECT $1, roles.id FROM roles WHERE name = ANY($2)
+		  ON CONFLICT DO NOTHING`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(roles))
+
+	return err
+}
+
+func (m RoleModel) RemoveForUsers(userID int64, roles ...string) error {
+	query := `DELETE FROM users_roles
+		  USING roles
+		  WHERE users_roles.role_id = roles.id
+		  AND users_roles.user_id = $1
+		  AND roles.name = ANY($2)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(roles))
+
+	return err
+}
@@ -0,0 +1,287 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	RuleArtistContains  = "artist_contains"
+	RuleGenreIn         = "genre_in"
+	RuleNameMatches     = "name_matches"
+	RuleArtistMatches   = "artist_matches"
+	RuleAddedWithinDays = "added_within_days"
+	RuleInPlaylist      = "in_playlist"
+	RuleNotInPlaylist   = "not_in_playlist"
+)
+
+var RuleTypes = []string{
+	RuleArtistContains,
+	RuleGenreIn,
+	RuleNameMatches,
+	RuleArtistMatches,
+	RuleAddedWithinDays,
+	RuleInPlaylist,
+	RuleNotInPlaylist,
+}
+
+var SmartOrderSafelist = []string{
+	"name", "artist", "year", "duration", "created_at",
+	"-name", "-artist", "-year", "-duration", "-created_at",
+	"random",
+}
+
+// SmartRule is one condition of a smart playlist. Which value field is used
+// depends on Type: Value for the text rules, Values for genre_in, Days for
+// added_within_days and PlaylistID for the playlist rules.
+type SmartRule struct {
+	Type       string   `json:"type"`
+	Value      string   `json:"value,omitempty"`
+	Values     []string `json:"values,omitempty"`
+	Days       int      `json:"days,omitempty"`
+	PlaylistID int64    `json:"playlist_id,omitempty"`
+}
+
+// SmartRules picks the songs of a smart playlist: those matching all, or any,
+// of the rules, sorted by Order and cut off after Limit songs.
+type SmartRules struct {
+	Match string      `json:"match"`
+	Rules []SmartRule `json:"rules"`
+	Limit int         `json:"limit"`
+	Order string      `json:"order"`
+}
+
+// Normalize fills in the defaults and tidies rule values the same way song
+// fields are tidied before they are stored.
+func (s *SmartRules) Normalize() {
+	if s.Match == "" {
+		s.Match = "all"
+	}
+	if s.Limit == 0 {
+		s.Limit = 100
+	}
+	if s.Order == "" {
+		s.Order = "-created_at"
+	}
+
+	for i := range s.Rules {
+		s.Rules[i].Value = strings.TrimSpace(s.Rules[i].Value)
+		if s.Rules[i].Type == RuleGenreIn {
+			s.Rules[i].Values = NormalizeGenres(s.Rules[i].Values)
+		}
+	}
+}
+
+// InPlaylists returns the ids of the playlists the rules refer to.
+func (s *SmartRules) InPlaylists() []int64 {
+	ids := []int64{}
+	for _, rule := range s.Rules {
+		if rule.Type == RuleInPlaylist || rule.Type == RuleNotInPlaylist {
+			ids = append(ids, rule.PlaylistID)
+		}
+	}
+	return ids
+}
+
+func ValidateSmartRules(v *validator.Validator, s *SmartRules) {
+	v.Check(!validator.PermittedValue(s.Match, "all", "any"), "rules.match", "must be all or any")
+	v.Check(len(s.Rules) == 0, "rules.rules", "must contain at least one rule")
+	v.Check(len(s.Rules) > 20, "rules.rules", "must not contain more than 20 rules")
+	v.Check(s.Limit < 1, "rules.limit", "must be greater than zero")
+	v.Check(s.Limit > 1000, "rules.limit", "must be a maximum of 1000")
+	v.Check(!validator.PermittedValue(s.Order, SmartOrderSafelist...), "rules.order", "invalid order value")
+
+	for i, rule := range s.Rules {
+		key := fmt.Sprintf("rules.rules[%d]", i)
+
+		switch rule.Type {
+		case RuleArtistContains, RuleNameMatches, RuleArtistMatches:
+			v.Check(rule.Value == "", key, "must have a value")
+			v.Check(len(rule.Value) > 500, key, "value must not be more than 500 bytes long")
+		case RuleGenreIn:
+			v.Check(len(rule.Values) == 0, key, "must have at least one genre in values")
+			v.Check(len(rule.Values) > 10, key, "cannot contain more than 10 genres")
+			for _, genre := range rule.Values {
+				v.Check(len(genre) > 50, key, "genres cannot be greater than 50 bytes")
+			}
+		case RuleAddedWithinDays:
+			v.Check(rule.Days < 1, key, "days must be greater than zero")
+			v.Check(rule.Days > 36500, key, "days must be a maximum of 36500")
+		case RuleInPlaylist, RuleNotInPlaylist:
+			v.Check(rule.PlaylistID < 1, key, "must have a playlist_id")
+		default:
+			v.Add(key, "type must be one of "+strings.Join(RuleTypes, ", "))
+		}
+	}
+}
+
+func (s SmartRules) Value() (driver.Value, error) {
+	return json.Marshal(s)
+}
+
+func (s *SmartRules) Scan(src any) error {
+	b, ok := src.([]byte)
+	if !ok {
+		return errors.New("type assertion to []byte failed")
+	}
+	return json.Unmarshal(b, s)
+}
+
+// where compiles the rules into a condition on songs. Rule values are never
+// written into the SQL, each is appended to args and referred to by its
+// placeholder. ownerID is the user the playlist rules are evaluated for.
+func (s *SmartRules) where(ownerID int64, args []any) (string, []any) {
+	placeholder := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	// A playlist rule only sees the songs of playlists the owner can see,
+	// so losing access to a playlist does not leak its songs.
+	visibleSongs := func(playlistID int64) string {
+		return fmt.Sprintf(`SELECT playlist_songs.song_id FROM playlist_songs
+			INNER JOIN playlists ON playlists.id = playlist_songs.playlist_id
+			WHERE playlist_songs.playlist_id = %s
+			AND (playlists.user_id = %[2]s OR playlists.visibility <> 'private'
+			OR EXISTS (SELECT 1 FROM playlist_members WHERE playlist_members.playlist_id = playlists.id AND playlist_members.user_id = %[2]s))`,
+			placeholder(playlistID), placeholder(ownerID))
+	}
+
+	conditions := make([]string, 0, len(s.Rules))
+
+	for _, rule := range s.Rules {
+		var condition string
+
+		switch rule.Type {
+		case RuleArtistContains:
+			condition = fmt.Sprintf("strpos(lower(songs.artist), lower(%s)) > 0", placeholder(rule.Value))
+		case RuleGenreIn:
+			condition = fmt.Sprintf("songs.genres && %s::text[]", placeholder(pq.Array(rule.Values)))
+		case RuleNameMatches:
+			condition = fmt.Sprintf("to_tsvector('simple', songs.name) @@ plainto_tsquery('simple', %s)", placeholder(rule.Value))
+		case RuleArtistMatches:
+			condition = fmt.Sprintf("to_tsvector('simple', songs.artist) @@ plainto_tsquery('simple', %s)", placeholder(rule.Value))
+		case RuleAddedWithinDays:
+			condition = fmt.Sprintf("songs.created_at >= now() - make_interval(days => %s::int)", placeholder(rule.Days))
+		case RuleInPlaylist:
+			condition = fmt.Sprintf("songs.id IN (%s)", visibleSongs(rule.PlaylistID))
+		case RuleNotInPlaylist:
+			condition = fmt.Sprintf("songs.id NOT IN (%s)", visibleSongs(rule.PlaylistID))
+		default:
+			condition = "false"
+		}
+
+		conditions = append(conditions, condition)
+	}
+
+	if len(conditions) == 0 {
+		return "false", args
+	}
+
+	operator := " AND "
+	if s.Match == "any" {
+		operator = " OR "
+	}
+
+	return "(" + strings.Join(conditions, operator) + ")", args
+}
+
+// orderBy is the ORDER BY expression for the rule order. Order must have
+// been validated against SmartOrderSafelist.
+func (s *SmartRules) orderBy() string {
+	if s.Order == "random" {
+		return "random()"
+	}
+
+	f := Filters{Sort: s.Order, SortSafelist: SmartOrderSafelist}
+	return "songs." + f.sortColumn() + " " + f.sortDirection()
+}
+
+// GetSmartSongs evaluates the rules of a smart playlist and returns the
+// matching songs, numbered in rule order, narrowed down by the same filters
+// as GetSongsFromPlaylist.
+func (m *PlaylistModel) GetSmartSongs(playlist *Playlist, artist string, name string, genres GenreFilter, filters Filters) ([]*PlaylistSong, error) {
+	if playlist.Rules == nil {
+		return []*PlaylistSong{}, nil
+	}
+
+	sortColumn := "songs." + filters.sortColumn()
+	if filters.sortColumn() == "position" {
+		sortColumn = "smart.position"
+	}
+
+	args := append([]any{playlist.Rules.Limit, artist, name}, genres.args()...)
+	args = append(args, filters.limit(), filters.offset())
+
+	where, args := playlist.Rules.where(playlist.UserID, args)
+
+	query := fmt.Sprintf(`
+	WITH smart AS (
+		SELECT songs.id, row_number() OVER (ORDER BY %s, songs.id) AS position
+		FROM songs
+		WHERE %s
+	)
+	SELECT smart.position, songs.id, songs.created_at, songs.artist, songs.name, songs.song_url, songs.thumbnail,
+	COALESCE(songs.album_id, 0), songs.track_number, songs.disc_number, songs.year, songs.duration, songs.genres, songs.version
+	FROM smart
+	INNER JOIN songs ON songs.id = smart.id
+	WHERE smart.position <= $1
+	AND (to_tsvector('simple', songs.artist) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	AND (to_tsvector('simple', songs.name) @@ plainto_tsquery('simple', $3) OR $3 = '')
+	AND (cardinality($4::text[]) = 0 OR ($5 = 'all' AND songs.genres @> $4) OR ($5 = 'any' AND songs.genres && $4))
+	ORDER BY %s %s, smart.position ASC
+	LIMIT $6 OFFSET $7
+	`, playlist.Rules.orderBy(), where, sortColumn, filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	songs := []*PlaylistSong{}
+
+	for rows.Next() {
+		song := PlaylistSong{Song: &Song{}}
+
+		err := rows.Scan(
+			&song.Position,
+			&song.ID,
+			&song.Created_At,
+			&song.Artist,
+			&song.Name,
+			&song.SongURL,
+			&song.Thumbnail,
+			&song.AlbumID,
+			&song.TrackNumber,
+			&song.DiscNumber,
+			&song.Year,
+			&song.Duration,
+			pq.Array(&song.Genres),
+			&song.Version,
+		)
+
+		if err != nil {
+			return nil, err
+		}
+
+		songs = append(songs, &song)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return songs, nil
+}
@@ -8,16 +8,25 @@ import (
 	"time"
 
 	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
 )
 
 type Song struct {
-	ID         int64
-	Created_At time.Time
-	Name       string
-	SongURL    string
-	Artist     string
-	Thumbnail  string
-	Version    int
+	ID          int64     `json:"id"`
+	Created_At  time.Time `json:"created_at"`
+	Name        string    `json:"name"`
+	SongURL     string    `json:"song_url"`
+	Artist      string    `json:"artist"`
+	Thumbnail   string    `json:"thumbnail"`
+	AlbumID     int64     `json:"album_id,omitempty"`
+	TrackNumber int       `json:"track_number"`
+	DiscNumber  int       `json:"disc_number"`
+	Year        int       `json:"year"`
+	Duration    int       `json:"duration"`
+	Genres      []string  `json:"genres,omitempty"`
+	ContentHash string    `json:"content_hash,omitempty"`
+	MediaKey    string    `json:"-"`
+	Version     int       `json:"version"`
 }
 
 type SongModel struct {
@@ -26,33 +35,106 @@ type SongModel struct {
 
 func ValidateSong(v *validator.Validator, song *Song) {
 	v.Check(song.Name == "", "name", "name cannot be blank")
-	v.Check(len(song.Name) > 30, "name", "name cannot be greater than 30 bytes")
+	v.Check(len(song.Name) > 200, "name", "name cannot be greater than 200 bytes")
 
 	v.Check(song.Artist == "", "artist", "artist cannot be blank")
-	v.Check(len(song.Artist) > 50, "artist", "artist name cannot be greater than 50 bytes")
+	v.Check(len(song.Artist) > 200, "artist", "artist name cannot be greater than 200 bytes")
 
-	v.Check(len(song.SongURL) > 100, "url", "song url cannot be greater than 100")
-	v.Check(len(song.Thumbnail) > 100, "thumbnail", "thumnbail cannot be greater than 100")
+	v.Check(len(song.SongURL) > 500, "url", "song url cannot be greater than 500")
+	v.Check(len(song.Thumbnail) > 500, "thumbnail", "thumnbail cannot be greater than 500")
+
+	v.Check(song.AlbumID < 0, "album_id", "album id cannot be negative")
+	v.Check(song.TrackNumber < 0, "track_number", "track number cannot be negative")
+	v.Check(song.DiscNumber < 0, "disc_number", "disc number cannot be negative")
+	v.Check(song.Year < 0 || song.Year > time.Now().Year()+1, "year", "year must be a valid year")
+	v.Check(song.Duration < 0, "duration", "duration cannot be negative")
+
+	ValidateGenres(v, song.Genres)
 }
 
 func (m *SongModel) Insert(song *Song) error {
-	query := `INSERT INTO songs (name, artist, song_url, thumbnail) 
-		  VALUES ($1, $2, $3, $4)
+	query := `INSERT INTO songs (name, artist, song_url, thumbnail, album_id, track_number, disc_number, year, duration, genres) 
+		  VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8, $9, COALESCE($10::text[], '{}'))
 		  RETURNING id, created_at, version`
 
-	args := []any{song.Name, song.Artist, song.SongURL, song.Thumbnail}
+	args := []any{song.Name, song.Artist, song.SongURL, song.Thumbnail, song.AlbumID, song.TrackNumber, song.DiscNumber, song.Year, song.Duration, pq.Array(song.Genres)}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
 	return m.DB.QueryRowContext(ctx, query, args...).Scan(&song.ID, &song.Created_At, &song.Version)
 }
 
+// InsertWithMedia inserts a song backed by a content addressed media object.
+// An object taken with MediaObjectModel.Acquire already carries the song's
+// reference; a new one is registered, or another reference is taken on it if
+// the same file was stored in the meantime.
+func (m *SongModel) InsertWithMedia(song *Song, object *MediaObject) error {
+	if object.ID != 0 {
+		return m.insertWithAcquiredMedia(song, object)
+	}
+
+	query := `WITH media AS (
+			INSERT INTO media_objects (hash, key, size, content_type, cover_key, ref_count)
+			VALUES ($11, $12, $13, $14, $15, 1)
+			ON CONFLICT (hash) DO UPDATE SET ref_count = media_objects.ref_count + 1, released_at = NULL
+			RETURNING id, key
+		  )
+		  INSERT INTO songs (name, artist, song_url, thumbnail, album_id, track_number, disc_number, year, duration, genres, media_object_id) 
+		  SELECT $1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8, $9, COALESCE($10::text[], '{}'), media.id FROM media
+		  RETURNING id, created_at, version, (SELECT key FROM media)`
+
+	args := []any{
+		song.Name, song.Artist, song.SongURL, song.Thumbnail, song.AlbumID, song.TrackNumber, song.DiscNumber, song.Year, song.Duration, pq.Array(song.Genres),
+		object.Hash, object.Key, object.Size, object.ContentType, object.CoverKey,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&song.ID, &song.Created_At, &song.Version, &song.MediaKey)
+	if err != nil {
+		return err
+	}
+
+	song.ContentHash = object.Hash
+
+	return nil
+}
+
+func (m *SongModel) insertWithAcquiredMedia(song *Song, object *MediaObject) error {
+	query := `INSERT INTO songs (name, artist, song_url, thumbnail, album_id, track_number, disc_number, year, duration, genres, media_object_id) 
+		  VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7, $8, $9, COALESCE($10::text[], '{}'), $11)
+		  RETURNING id, created_at, version`
+
+	args := []any{
+		song.Name, song.Artist, song.SongURL, song.Thumbnail, song.AlbumID, song.TrackNumber, song.DiscNumber, song.Year, song.Duration, pq.Array(song.Genres),
+		object.ID,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&song.ID, &song.Created_At, &song.Version)
+	if err != nil {
+		return err
+	}
+
+	song.ContentHash = object.Hash
+	song.MediaKey = object.Key
+
+	return nil
+}
+
 func (m *SongModel) Get(id int64) (*Song, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT id, created_at, artist, song_url, thumbnail, version FROM songs
-		  WHERE id = $1 `
+	query := `SELECT songs.id, songs.created_at, songs.name, songs.artist, songs.song_url, songs.thumbnail,
+		  COALESCE(songs.album_id, 0), songs.track_number, songs.disc_number, songs.year, songs.duration, songs.genres,
+		  COALESCE(media_objects.hash, ''), COALESCE(media_objects.key, ''), songs.version
+		  FROM songs
+		  LEFT JOIN media_objects ON media_objects.id = songs.media_object_id
+		  WHERE songs.id = $1 `
 
 	song := &Song{}
 
@@ -62,9 +144,18 @@ func (m *SongModel) Get(id int64) (*Song, error) {
 	err := m.DB.QueryRowContext(ctx, query, id).Scan(
 		&song.ID,
 		&song.Created_At,
+		&song.Name,
 		&song.Artist,
-		&song.Thumbnail,
 		&song.SongURL,
+		&song.Thumbnail,
+		&song.AlbumID,
+		&song.TrackNumber,
+		&song.DiscNumber,
+		&song.Year,
+		&song.Duration,
+		pq.Array(&song.Genres),
+		&song.ContentHash,
+		&song.MediaKey,
 		&song.Version,
 	)
 
@@ -79,21 +170,23 @@ func (m *SongModel) Get(id int64) (*Song, error) {
 	return song, nil
 }
 
-func (m *SongModel) GetAll(artist string, name string, filters Filters) ([]*Song, error) {
+func (m *SongModel) GetAll(artist string, name string, genres GenreFilter, filters Filters) ([]*Song, error) {
 
 	query := fmt.Sprintf(`
-	SELECT id, created_at, artist, name, song_url, thumbnail, version 
+	SELECT id, created_at, artist, name, song_url, thumbnail, COALESCE(album_id, 0), track_number, disc_number, year, duration, genres, version 
 	FROM songs 
-	WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '') 
-	AND (genres @> $2 OR $2 = '{}')
+	WHERE (to_tsvector('simple', artist) @@ plainto_tsquery('simple', $1) OR $1 = '') 
+	AND (to_tsvector('simple', name) @@ plainto_tsquery('simple', $2) OR $2 = '')
+	AND (cardinality($3::text[]) = 0 OR ($4 = 'all' AND genres @> $3) OR ($4 = 'any' AND genres && $3))
 	ORDER BY %s %s, id ASC
-	LIMIT $3 OFFSET $4
+	LIMIT $5 OFFSET $6
 	`, filters.sortColumn(), filters.sortDirection())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	args := []any{artist, name, filters.limit(), filters.offset()}
+	args := append([]any{artist, name}, genres.args()...)
+	args = append(args, filters.limit(), filters.offset())
 
 	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
@@ -110,8 +203,16 @@ func (m *SongModel) GetAll(artist string, name string, filters Filters) ([]*Song
 		err := rows.Scan(
 			&song.ID,
 			&song.Created_At,
-			&song.Name,
 			&song.Artist,
+			&song.Name,
+			&song.SongURL,
+			&song.Thumbnail,
+			&song.AlbumID,
+			&song.TrackNumber,
+			&song.DiscNumber,
+			&song.Year,
+			&song.Duration,
+			pq.Array(&song.Genres),
 			&song.Version,
 		)
 
@@ -127,17 +228,31 @@ func (m *SongModel) GetAll(artist string, name string, filters Filters) ([]*Song
 
 	return songs, nil
 }
+
+// GetAllSongs returns every song in a playlist in playlist order, including
+// the content hash of uploaded songs.
 func (m *SongModel) GetAllSongs(playlistID int64) ([]*Song, error) {
-	query := `SELECT songs.id, songs.created_at, songs.name, songs.song_url, songs.artist, songs.thumbnail, version 
+	query := `SELECT songs.id, songs.created_at, songs.name, songs.artist, songs.song_url, songs.thumbnail,
+		  COALESCE(songs.album_id, 0), songs.track_number, songs.disc_number, songs.year, songs.duration, songs.genres,
+		  COALESCE(media_objects.hash, ''), songs.version
 		  FROM playlist_songs
-		  WHERE playlist_id = $1
-		  INNER JOIN playlistsongs ON songs.id = playlistsongs.song_id`
+		  INNER JOIN songs ON songs.id = playlist_songs.song_id
+		  LEFT JOIN media_objects ON media_objects.id = songs.media_object_id
+		  WHERE playlist_songs.playlist_id = $1
+		  ORDER BY playlist_songs.position ASC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	rows, err := m.DB.Query(query, playlistID)
+	rows, err := m.DB.QueryContext(ctx, query, playlistID)
 	if err != nil {
 		return nil, err
 	}
+
+	defer rows.Close()
+
 	songs := []*Song{}
+
 	for rows.Next() {
 		var song Song
 
@@ -146,6 +261,15 @@ func (m *SongModel) GetAllSongs(playlistID int64) ([]*Song, error) {
 			&song.Created_At,
 			&song.Name,
 			&song.Artist,
+			&song.SongURL,
+			&song.Thumbnail,
+			&song.AlbumID,
+			&song.TrackNumber,
+			&song.DiscNumber,
+			&song.Year,
+			&song.Duration,
+			pq.Array(&song.Genres),
+			&song.ContentHash,
 			&song.Version,
 		)
 
@@ -159,18 +283,103 @@ func (m *SongModel) GetAllSongs(playlistID int64) ([]*Song, error) {
 		return nil, err
 	}
 
-	return nil, nil
+	return songs, nil
+}
+
+// GetIDsByContentHash maps each of hashes that uploaded audio exists for to
+// the oldest song using that audio.
+func (m *SongModel) GetIDsByContentHash(hashes []string) (map[string]int64, error) {
+	query := `SELECT DISTINCT ON (media_objects.hash) media_objects.hash, songs.id
+		  FROM songs
+		  INNER JOIN media_objects ON media_objects.id = songs.media_object_id
+		  WHERE media_objects.hash = ANY($1)
+		  ORDER BY media_objects.hash, songs.id ASC`
+
+	return getIDs[string](m.DB, query, pq.Array(hashes))
+}
+
+// GetExistingIDs returns which of ids are songs that exist.
+func (m *SongModel) GetExistingIDs(ids []int64) (map[int64]int64, error) {
+	query := `SELECT id, id FROM songs WHERE id = ANY($1)`
+
+	return getIDs[int64](m.DB, query, pq.Array(ids))
+}
+
+// GetIDsBySongURL maps each of urls to the oldest song stored with it.
+func (m *SongModel) GetIDsBySongURL(urls []string) (map[string]int64, error) {
+	query := `SELECT DISTINCT ON (song_url) song_url, id
+		  FROM songs
+		  WHERE song_url = ANY($1)
+		  ORDER BY song_url, id ASC`
+
+	return getIDs[string](m.DB, query, pq.Array(urls))
+}
+
+// GetIDsByNameArtist matches names[i] and artists[i] to a song ignoring case,
+// keyed by i. An empty artist matches on the name alone, an empty name never
+// matches.
+func (m *SongModel) GetIDsByNameArtist(names []string, artists []string) (map[int64]int64, error) {
+	query := `SELECT DISTINCT ON (entries.n) entries.n - 1, songs.id
+		  FROM unnest($1::text[], $2::text[]) WITH ORDINALITY AS entries(name, artist, n)
+		  INNER JOIN songs ON lower(songs.name) = lower(entries.name)
+		  AND (lower(songs.artist) = lower(entries.artist) OR entries.artist = '')
+		  WHERE entries.name <> ''
+		  ORDER BY entries.n, songs.id ASC`
+
+	return getIDs[int64](m.DB, query, pq.Array(names), pq.Array(artists))
+}
+
+// getIDs runs a query selecting pairs of a key and a song id.
+func getIDs[K comparable](db *sql.DB, query string, args ...any) (map[K]int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := make(map[K]int64)
+
+	for rows.Next() {
+		var (
+			key K
+			id  int64
+		)
+
+		err := rows.Scan(&key, &id)
+		if err != nil {
+			return nil, err
+		}
+
+		ids[key] = id
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
 }
 
 func (m *SongModel) Update(song *Song) error {
-	query := `UPDATE songs SET artist = $1, thumbnail = $2, song_url = $3, version = version + 1
-	WHERE id = $4 AND version = $5
+	query := `UPDATE songs SET name = $1, artist = $2, thumbnail = $3, song_url = $4, album_id = NULLIF($5, 0),
+	track_number = $6, disc_number = $7, year = $8, duration = $9, genres = COALESCE($10::text[], '{}'), version = version + 1
+	WHERE id = $11 AND version = $12
 	RETURNING version`
 
 	args := []any{
+		song.Name,
 		song.Artist,
 		song.Thumbnail,
 		song.SongURL,
+		song.AlbumID,
+		song.TrackNumber,
+		song.DiscNumber,
+		song.Year,
+		song.Duration,
+		pq.Array(song.Genres),
 		song.ID,
 		song.Version,
 	}
@@ -192,29 +401,43 @@ func (m *SongModel) Update(song *Song) error {
 
 }
 
+// Delete removes a song, dropping its reference to the media object it was
+// uploaded as.
 func (m *SongModel) Delete(id int64) error {
 	if id < 1 {
 		return ErrRecordNotFound
 	}
 	query := `DELETE FROM songs
-		  WHERE id = $1 `
+		  WHERE id = $1 
+		  RETURNING media_object_id`
 
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	result, err := m.DB.ExecContext(ctx, query, id)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
+
+	var mediaObjectID sql.NullInt64
 
-	rowsAffected, err := result.RowsAffected()
+	err = tx.QueryRowContext(ctx, query, id).Scan(&mediaObjectID)
 	if err != nil {
-		return err
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrRecordNotFound
+		default:
+			return err
+		}
 	}
 
-	if rowsAffected == 0 {
-		return ErrRecordNotFound
+	if mediaObjectID.Valid {
+		err = MediaObjectModel{DB: m.DB}.release(ctx, tx, mediaObjectID.Int64)
+		if err != nil {
+			return err
+		}
 	}
 
-	return nil
+	return tx.Commit()
 }
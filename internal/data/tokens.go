@@ -6,22 +6,44 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
 	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopePasswordReset  = "password-reset"
+	ScopeRefresh        = "refresh"
 )
 
+var ErrTokenReused = errors.New("refresh token reused")
+
 type Token struct {
 	Plaintext string    `json:"token"`
 	UserID    int64     `json:"-"`
 	Hash      []byte    `json:"-"`
 	Expiry    time.Time `json:"expiry"`
 	Scope     string    `json:"-"`
+	UserAgent string    `json:"-"`
+	IP        string    `json:"-"`
+	FamilyID  int64     `json:"-"`
+}
+
+// Session describes a login without revealing its tokens: the access and
+// refresh tokens of one family. LastUsedAt lags behind real use by up to the
+// interval usage is flushed at.
+type Session struct {
+	ID         int64      `json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Expiry     time.Time  `json:"expiry"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	Current    bool       `json:"current"`
 }
 
 type TokenModel struct {
@@ -68,12 +90,150 @@ func (m *TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token,
 	return token, nil
 }
 
+// NewSession logs a client in, creating a token family holding a short lived
+// authentication token and the refresh token used to replace it.
+func (m *TokenModel) NewSession(userID int64, accessTTL time.Duration, refreshTTL time.Duration, userAgent string, ip string) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	var familyID int64
+
+	err = tx.QueryRowContext(ctx, `SELECT nextval('token_families_id_seq')`).Scan(&familyID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := m.insertPair(ctx, tx, userID, familyID, accessTTL, refreshTTL, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+// Rotate trades an unused refresh token for a new pair in the same family.
+// The old refresh token is kept, marked used, and the old authentication
+// token is revoked. Presenting a used refresh token means it leaked: the
+// whole family is revoked and ErrTokenReused returned.
+func (m *TokenModel) Rotate(plaintext string, accessTTL time.Duration, refreshTTL time.Duration, userAgent string, ip string) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT user_id, family_id, used_at IS NOT NULL
+		  FROM tokens
+		  WHERE hash = $1 AND scope = $2 AND expiry > now() AND family_id IS NOT NULL
+		  FOR UPDATE`
+
+	var (
+		userID   int64
+		familyID int64
+		used     bool
+	)
+
+	err = tx.QueryRowContext(ctx, query, HashPlaintext(plaintext), ScopeRefresh).Scan(&userID, &familyID, &used)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	if used {
+		_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1`, familyID)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return nil, nil, ErrTokenReused
+	}
+
+	_, err = tx.ExecContext(ctx, `UPDATE tokens SET used_at = now() WHERE hash = $1`, HashPlaintext(plaintext))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE family_id = $1 AND scope = $2`, familyID, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	access, refresh, err := m.insertPair(ctx, tx, userID, familyID, accessTTL, refreshTTL, userAgent, ip)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return access, refresh, nil
+}
+
+func (m *TokenModel) insertPair(ctx context.Context, tx *sql.Tx, userID int64, familyID int64, accessTTL time.Duration, refreshTTL time.Duration, userAgent string, ip string) (*Token, *Token, error) {
+	access, err := generateToken(userID, accessTTL, ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	refresh, err := generateToken(userID, refreshTTL, ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query := `INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip, family_id)
+		  VALUES ($1, $2, $3, $4, $5, $6, $7)`
+
+	for _, token := range []*Token{access, refresh} {
+		token.UserAgent = userAgent
+		token.IP = ip
+		token.FamilyID = familyID
+
+		_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP, token.FamilyID)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return access, refresh, nil
+}
+
+// HashPlaintext returns the hash a token is stored under.
+func HashPlaintext(plaintext string) []byte {
+	hash := sha256.Sum256([]byte(plaintext))
+	return hash[:]
+}
+
 func (m *TokenModel) Insert(token *Token) error {
-	query := `INSERT INTO tokens (hash, user_id, expiry, scope)
-		  VALUES ($1, $2, $3, $4)
+	query := `INSERT INTO tokens (hash, user_id, expiry, scope, user_agent, ip)
+		  VALUES ($1, $2, $3, $4, $5, $6)
 		`
 
-	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
+	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope, token.UserAgent, token.IP}
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -90,3 +250,155 @@ func (m *TokenModel) DeleteAllForUsers(scope string, user_id int64) error {
 	_, err := m.DB.ExecContext(ctx, query, scope, user_id)
 	return err
 }
+
+// DeleteAllForUser revokes every token of the user, whatever its scope.
+func (m *TokenModel) DeleteAllForUser(userID int64) error {
+	query := `DELETE FROM tokens WHERE user_id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID)
+	return err
+}
+
+// DeleteFamily revokes the token with the given hash together with every
+// other token of its family.
+func (m *TokenModel) DeleteFamily(hash []byte) error {
+	query := `DELETE FROM tokens
+		  WHERE hash = $1
+		  OR family_id = (SELECT family_id FROM tokens WHERE hash = $1)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hash)
+	return err
+}
+
+// DeleteByHash revokes a single token.
+func (m *TokenModel) DeleteByHash(hash []byte) error {
+	query := `DELETE FROM tokens WHERE hash = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, hash)
+	return err
+}
+
+// RecordFailedAttempt counts a wrong code entered with the token and deletes
+// the token once it reaches max attempts.
+func (m *TokenModel) RecordFailedAttempt(hash []byte, max int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `UPDATE tokens SET attempts = attempts + 1 WHERE hash = $1`, hash)
+	if err != nil {
+		return err
+	}
+
+	_, err = m.DB.ExecContext(ctx, `DELETE FROM tokens WHERE hash = $1 AND attempts >= $2`, hash, max)
+	return err
+}
+
+// GetSessions returns the logins of a user that can still be used, most
+// recently used first. A session is identified by the id of the oldest
+// token in its family; the one holding currentHash is marked current.
+func (m *TokenModel) GetSessions(userID int64, currentHash []byte) ([]*Session, error) {
+	query := `SELECT min(id), min(created_at), max(last_used_at), max(expiry) FILTER (WHERE used_at IS NULL),
+		  (array_agg(user_agent ORDER BY id DESC))[1], (array_agg(ip ORDER BY id DESC))[1], bool_or(hash = $4)
+		  FROM tokens
+		  WHERE user_id = $1 AND scope IN ($2, $3)
+		  GROUP BY COALESCE(family_id, -id)
+		  HAVING max(expiry) FILTER (WHERE used_at IS NULL) > now()
+		  ORDER BY COALESCE(max(last_used_at), min(created_at)) DESC, min(id) DESC`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, ScopeAuthentication, ScopeRefresh, currentHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*Session{}
+
+	for rows.Next() {
+		var session Session
+
+		err := rows.Scan(
+			&session.ID,
+			&session.CreatedAt,
+			&session.LastUsedAt,
+			&session.Expiry,
+			&session.UserAgent,
+			&session.IP,
+			&session.Current,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		sessions = append(sessions, &session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
+
+// DeleteSession revokes a session listed by GetSessions.
+func (m *TokenModel) DeleteSession(id int64, userID int64) error {
+	query := `DELETE FROM tokens
+		  WHERE user_id = $2 AND scope IN ($3, $4)
+		  AND (id = $1 OR family_id = (SELECT family_id FROM tokens WHERE id = $1 AND user_id = $2))`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id, userID, ScopeAuthentication, ScopeRefresh)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+// TouchAll records when tokens were last used in one statement. Times older
+// than the stored one are ignored.
+func (m *TokenModel) TouchAll(lastUsed map[string]time.Time) error {
+	if len(lastUsed) == 0 {
+		return nil
+	}
+
+	hashes := make([][]byte, 0, len(lastUsed))
+	times := make([]string, 0, len(lastUsed))
+
+	for hash, t := range lastUsed {
+		hashes = append(hashes, []byte(hash))
+		times = append(times, t.UTC().Format(time.RFC3339))
+	}
+
+	query := `UPDATE tokens SET last_used_at = used.at
+		  FROM unnest($1::bytea[], $2::timestamptz[]) AS used(hash, at)
+		  WHERE tokens.hash = used.hash
+		  AND (tokens.last_used_at IS NULL OR tokens.last_used_at < used.at)`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, pq.Array(hashes), pq.Array(times))
+	return err
+}
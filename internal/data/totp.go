@@ -0,0 +1,257 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
+)
+
+const (
+	ScopeMFA = "mfa"
+
+	// MFAMaxAttempts is how many wrong codes an MFA token survives.
+	MFAMaxAttempts = 5
+
+	recoveryCodeCount = 10
+)
+
+var TOTPCodeRX = regexp.MustCompile(`^[0-9]{6}$`)
+
+// TOTP is the two-factor state of a user. Secret is set as soon as the user
+// starts enrolling, Enabled only once they proved their app produces codes
+// for it.
+type TOTP struct {
+	UserID      int64
+	Secret      string
+	Enabled     bool
+	LastCounter int64
+}
+
+type TOTPModel struct {
+	DB *sql.DB
+}
+
+// GenerateRecoveryCodes returns one-time codes in the form xxxxx-xxxxx,
+// each good for 50 random bits.
+func GenerateRecoveryCodes() ([]string, error) {
+	codes := make([]string, recoveryCodeCount)
+
+	for i := range codes {
+		randomBytes := make([]byte, 7)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		code := strings.ToLower(base32.StdEncoding.EncodeToString(randomBytes))[:10]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lets a code be entered in any case, with or without
+// its dash.
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.ReplaceAll(code, "-", "")
+}
+
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `SELECT id, COALESCE(totp_secret, ''), totp_enabled, totp_last_counter
+		  FROM users
+		  WHERE id = $1`
+
+	var totp TOTP
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(
+		&totp.UserID,
+		&totp.Secret,
+		&totp.Enabled,
+		&totp.LastCounter,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &totp, nil
+}
+
+// SetSecret starts an enrollment, replacing the secret of any enrollment that
+// was not finished. It fails with ErrEditConflict once 2FA is enabled.
+func (m TOTPModel) SetSecret(userID int64, secret string) error {
+	query := `UPDATE users SET totp_secret = $2, totp_last_counter = 0
+		  WHERE id = $1 AND NOT totp_enabled`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	return nil
+}
+
+// Enable turns 2FA on and replaces the user's recovery codes. counter is the
+// time step of the code that confirmed the enrollment, so it cannot be used
+// again to log in.
+func (m TOTPModel) Enable(userID int64, counter int64, recoveryCodes []string) error {
+	hashes := make([][]byte, len(recoveryCodes))
+	for i, code := range recoveryCodes {
+		hashes[i] = HashPlaintext(NormalizeRecoveryCode(code))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_enabled = true, totp_last_counter = $2
+		  WHERE id = $1 AND totp_secret IS NOT NULL AND NOT totp_enabled`
+
+	result, err := tx.ExecContext(ctx, query, userID, counter)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrEditConflict
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	query = `INSERT INTO recovery_codes (user_id, hash)
+		 SELECT $1, unnest($2::bytea[])`
+
+	_, err = tx.ExecContext(ctx, query, userID, pq.Array(hashes))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Disable turns 2FA off and forgets the secret and recovery codes.
+func (m TOTPModel) Disable(userID int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `UPDATE users SET totp_secret = NULL, totp_enabled = false, totp_last_counter = 0
+		  WHERE id = $1`
+
+	_, err = tx.ExecContext(ctx, query, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM recovery_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseCounter records that the code for counter was used. It reports false
+// when that code, or a later one, was used before.
+func (m TOTPModel) UseCounter(userID int64, counter int64) (bool, error) {
+	query := `UPDATE users SET totp_last_counter = $2
+		  WHERE id = $1 AND totp_last_counter < $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, counter)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// UseRecoveryCode spends one of the user's recovery codes. It reports false
+// when the code is unknown or was already used.
+func (m TOTPModel) UseRecoveryCode(userID int64, code string) (bool, error) {
+	query := `UPDATE recovery_codes SET used_at = now()
+		  WHERE user_id = $1 AND hash = $2 AND used_at IS NULL`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, HashPlaintext(NormalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected == 1, nil
+}
+
+// ValidateSecondFactor checks that exactly one of an authenticator code and a
+// recovery code was sent.
+func ValidateSecondFactor(v *validator.Validator, code string, recoveryCode string) {
+	v.Check(code == "" && recoveryCode == "", "code", "must be provided")
+	v.Check(code != "" && recoveryCode != "", "code", "cannot be sent together with a recovery code")
+
+	if code != "" {
+		v.Check(!validator.Matches(code, TOTPCodeRX), "code", "must be 6 digits")
+	}
+
+	if recoveryCode != "" {
+		v.Check(len(NormalizeRecoveryCode(recoveryCode)) != 10, "recovery_code", "must be 10 characters long")
+	}
+}
@@ -0,0 +1,220 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Arkitecth/apollo/validator"
+	"github.com/lib/pq"
+)
+
+type UploadSession struct {
+	ID          string    `json:"id"`
+	UserID      int64     `json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+	Expiry      time.Time `json:"expiry"`
+	Filename    string    `json:"filename"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	Offset      int64     `json:"offset"`
+	Chunks      int       `json:"chunks"`
+	ChunkKeys   []string  `json:"-"`
+	Version     int       `json:"-"`
+}
+
+// NewChunkKey returns a storage key for the next chunk of the session. Every
+// attempt at writing a chunk gets its own key, so a request that loses the
+// race for an offset cannot overwrite the chunk of the one that won it.
+func (s *UploadSession) NewChunkKey() (string, error) {
+	randomBytes := make([]byte, 8)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	suffix := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
+
+	return fmt.Sprintf("uploads/%s/%06d-%s", s.ID, s.Chunks, suffix), nil
+}
+
+// AddChunk records a chunk written under key, to be committed by Update.
+func (s *UploadSession) AddChunk(key string, size int64) {
+	s.ChunkKeys = append(s.ChunkKeys, key)
+	s.Chunks = len(s.ChunkKeys)
+	s.Offset += size
+}
+
+func (s *UploadSession) Complete() bool {
+	return s.Offset == s.Size
+}
+
+type UploadSessionModel struct {
+	DB *sql.DB
+}
+
+func ValidateUploadSession(v *validator.Validator, session *UploadSession, maxSize int64) {
+	v.Check(session.Filename == "", "filename", "must be provided")
+	v.Check(len(session.Filename) > 255, "filename", "must not be more than 255 bytes long")
+
+	v.Check(session.Size <= 0, "size", "must be greater than zero")
+	v.Check(session.Size > maxSize, "size", fmt.Sprintf("must not be more than %d bytes", maxSize))
+
+	v.Check(len(session.ContentType) > 100, "content_type", "must not be more than 100 bytes long")
+}
+
+func (m UploadSessionModel) New(userID int64, ttl time.Duration, filename string, contentType string, size int64) (*UploadSession, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	session := &UploadSession{
+		ID:          base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes),
+		UserID:      userID,
+		Expiry:      time.Now().Add(ttl),
+		Filename:    filename,
+		ContentType: contentType,
+		Size:        size,
+	}
+
+	err = m.Insert(session)
+	if err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+func (m UploadSessionModel) Insert(session *UploadSession) error {
+	query := `INSERT INTO upload_sessions (id, user_id, expiry, filename, content_type, size)
+		  VALUES ($1, $2, $3, $4, $5, $6)
+		  RETURNING created_at, upload_offset, chunks, chunk_keys, version`
+
+	args := []any{session.ID, session.UserID, session.Expiry, session.Filename, session.ContentType, session.Size}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&session.CreatedAt, &session.Offset, &session.Chunks, pq.Array(&session.ChunkKeys), &session.Version)
+}
+
+func (m UploadSessionModel) Get(id string, userID int64) (*UploadSession, error) {
+	query := `SELECT id, user_id, created_at, expiry, filename, content_type, size, upload_offset, chunks, chunk_keys, version
+		  FROM upload_sessions
+		  WHERE id = $1 AND user_id = $2 AND expiry > $3`
+
+	var session UploadSession
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, id, userID, time.Now()).Scan(
+		&session.ID,
+		&session.UserID,
+		&session.CreatedAt,
+		&session.Expiry,
+		&session.Filename,
+		&session.ContentType,
+		&session.Size,
+		&session.Offset,
+		&session.Chunks,
+		pq.Array(&session.ChunkKeys),
+		&session.Version,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return &session, nil
+}
+
+func (m UploadSessionModel) Update(session *UploadSession) error {
+	query := `UPDATE upload_sessions
+		  SET upload_offset = $1, chunks = $2, chunk_keys = $3, expiry = $4, version = version + 1
+		  WHERE id = $5 AND version = $6
+		  RETURNING version`
+
+	args := []any{session.Offset, session.Chunks, pq.Array(session.ChunkKeys), session.Expiry, session.ID, session.Version}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&session.Version)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m UploadSessionModel) Delete(id string) error {
+	query := `DELETE FROM upload_sessions WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
+
+func (m UploadSessionModel) GetAllExpired(limit int) ([]*UploadSession, error) {
+	query := `SELECT id, chunks, chunk_keys FROM upload_sessions
+		  WHERE expiry <= $1
+		  ORDER BY expiry
+		  LIMIT $2`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, time.Now(), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []*UploadSession{}
+
+	for rows.Next() {
+		var session UploadSession
+		err := rows.Scan(&session.ID, &session.Chunks, pq.Array(&session.ChunkKeys))
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, &session)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return sessions, nil
+}
@@ -5,6 +5,8 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
 	"github.com/Arkitecth/apollo/validator"
@@ -20,6 +22,7 @@ type User struct {
 	Email     string    `json:"email"`
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
+	Suspended bool      `json:"suspended"`
 	Version   int       `json:"-"`
 }
 
@@ -65,6 +68,20 @@ func (p *password) Matches(plaintextPassword string) (bool, error) {
 	return true, nil
 }
 
+// dummyPasswordHash is generated on first use with the same cost as real
+// password hashes.
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("apollo dummy password"), 12)
+	return hash
+})
+
+// DummyPasswordMatches takes as long as checking the password of an account,
+// so a login for an email nobody registered answers no faster than one with
+// a wrong password.
+func DummyPasswordMatches(plaintextPassword string) {
+	_ = bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(plaintextPassword))
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email == "", "email", "must be provided")
 	v.Check(!validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -118,7 +135,7 @@ func (m UserModel) Insert(user *User) error {
 
 func (m UserModel) GetByEmail(email string) (*User, error) {
 	query := ` 
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, suspended, version
 		FROM users
 		WHERE email = $1 
 		`
@@ -134,6 +151,7 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Suspended,
 		&user.Version,
 	)
 
@@ -149,9 +167,49 @@ func (m UserModel) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
+// GetForLogin returns the user with the given email together with their
+// failed logins, in one query so a login takes as long whether or not the
+// email has an account.
+func (m UserModel) GetForLogin(email string) (*User, *LoginAttempts, error) {
+	query := `SELECT id, created_at, name, email, password_hash, activated, suspended, version, failed_logins, locked_until
+		  FROM users
+		  WHERE email = $1`
+
+	var (
+		user     User
+		attempts LoginAttempts
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Suspended,
+		&user.Version,
+		&attempts.Failed,
+		&attempts.LockedUntil,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	return &user, &attempts, nil
+}
+
 func (m UserModel) GetById(id int64) (*User, error) {
 	query := `
-		SELECT id, created_at, name, email, password_hash, activated, version
+		SELECT id, created_at, name, email, password_hash, activated, suspended, version
 		FROM users
 		WHERE id = $1`
 
@@ -167,6 +225,7 @@ func (m UserModel) GetById(id int64) (*User, error) {
 		&user.Email,
 		&user.Password.hash,
 		&user.Activated,
+		&user.Suspended,
 		&user.Version,
 	)
 
@@ -179,13 +238,58 @@ func (m UserModel) GetById(id int64) (*User, error) {
 		}
 	}
 
-	return nil, err
+	return &user, nil
+}
+
+// GetAll returns the users whose name or email contains search.
+func (m UserModel) GetAll(search string, filters Filters) ([]*User, error) {
+	query := fmt.Sprintf(`
+	SELECT id, created_at, name, email, activated, suspended, version
+	FROM users
+	WHERE (strpos(lower(name), lower($1)) > 0 OR strpos(lower(email::text), lower($1)) > 0 OR $1 = '')
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3
+	`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, search, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+
+	for rows.Next() {
+		var user User
+
+		err := rows.Scan(
+			&user.ID,
+			&user.CreatedAt,
+			&user.Name,
+			&user.Email,
+			&user.Activated,
+			&user.Suspended,
+			&user.Version,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 
+	return users, nil
 }
 
 func (m UserModel) Update(user *User) error {
 	query := `UPDATE users 
-		  SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1 WHERE id = $5 AND version = $6
+		  SET name = $1, email = $2, password_hash = $3, activated = $4, suspended = $5, version = version + 1 WHERE id = $6 AND version = $7
 		  RETURNING version`
 
 	args := []any{
@@ -193,6 +297,7 @@ func (m UserModel) Update(user *User) error {
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.Suspended,
 		user.ID,
 		user.Version,
 	}
@@ -217,7 +322,7 @@ func (m UserModel) Update(user *User) error {
 func (m UserModel) GetUserFromToken(scope string, plaintext string) (*User, error) {
 	hash := sha256.Sum256([]byte(plaintext))
 
-	query := `SELECT users.id, users.created_at, users.name, users.email, users.activated
+	query := `SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.suspended, users.version
 		  FROM users 
 		  INNER JOIN tokens
 		  ON tokens.user_id = users.id
@@ -238,7 +343,10 @@ func (m UserModel) GetUserFromToken(scope string, plaintext string) (*User, erro
 		&user.CreatedAt,
 		&user.Name,
 		&user.Email,
+		&user.Password.hash,
 		&user.Activated,
+		&user.Suspended,
+		&user.Version,
 	)
 	if err != nil {
 		switch {
@@ -250,3 +358,30 @@ func (m UserModel) GetUserFromToken(scope string, plaintext string) (*User, erro
 	}
 	return &user, nil
 }
+
+func (m UserModel) Delete(id int64) error {
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `DELETE FROM users WHERE id = $1`
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+func readFLAC(r io.ReadSeeker, md *Metadata) error {
+	return readFLACAt(r, 0, md)
+}
+
+func readFLACAt(r io.ReadSeeker, offset int64, md *Metadata) error {
+	md.Format = FormatFLAC
+	offset += 4
+
+	for {
+		header, err := readAt(r, offset, 4)
+		if err != nil {
+			return err
+		}
+
+		last := header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4
+
+		switch blockType {
+		case 0, 4, 6:
+			block, err := readAt(r, offset, length)
+			if err != nil {
+				return err
+			}
+
+			switch blockType {
+			case 0:
+				readStreamInfo(block, md)
+			case 4:
+				readVorbisComment(block, md)
+			case 6:
+				picture, pictureType := readFLACPicture(block)
+				if picture != nil && (md.Picture == nil || pictureType == 3) {
+					md.Picture = picture
+				}
+			}
+		}
+
+		offset += length
+
+		if last || blockType == 127 {
+			return nil
+		}
+	}
+}
+
+func readStreamInfo(block []byte, md *Metadata) {
+	if len(block) < 18 {
+		return
+	}
+
+	sampleRate := int64(block[10])<<12 | int64(block[11])<<4 | int64(block[12])>>4
+	totalSamples := int64(block[13]&0x0f)<<32 | int64(binary.BigEndian.Uint32(block[14:18]))
+
+	if sampleRate > 0 && totalSamples > 0 {
+		md.Duration = time.Duration(totalSamples) * time.Second / time.Duration(sampleRate)
+	}
+}
+
+// readVorbisComment parses a comment block as used by FLAC and, after the
+// packet header, by Ogg Vorbis and Opus.
+func readVorbisComment(block []byte, md *Metadata) {
+	next := func() (string, bool) {
+		if len(block) < 4 {
+			return "", false
+		}
+		n := binary.LittleEndian.Uint32(block)
+		block = block[4:]
+		if uint64(n) > uint64(len(block)) {
+			return "", false
+		}
+		value := string(block[:n])
+		block = block[n:]
+		return value, true
+	}
+
+	if _, ok := next(); !ok {
+		return
+	}
+
+	if len(block) < 4 {
+		return
+	}
+	count := binary.LittleEndian.Uint32(block)
+	block = block[4:]
+
+	for range count {
+		comment, ok := next()
+		if !ok {
+			return
+		}
+
+		key, value, found := strings.Cut(comment, "=")
+		if !found {
+			continue
+		}
+
+		if strings.EqualFold(key, "METADATA_BLOCK_PICTURE") {
+			if md.Picture == nil {
+				md.Picture, _ = readFLACPicture(decodeBase64(value))
+			}
+			continue
+		}
+
+		applyVorbisComment(md, key, value)
+	}
+}
+
+func readFLACPicture(block []byte) (*Picture, uint32) {
+	field := func() ([]byte, bool) {
+		if len(block) < 4 {
+			return nil, false
+		}
+		n := binary.BigEndian.Uint32(block)
+		block = block[4:]
+		if uint64(n) > uint64(len(block)) {
+			return nil, false
+		}
+		value := block[:n]
+		block = block[n:]
+		return value, true
+	}
+
+	if len(block) < 4 {
+		return nil, 0
+	}
+	pictureType := binary.BigEndian.Uint32(block)
+	block = block[4:]
+
+	mimeType, ok := field()
+	if !ok {
+		return nil, 0
+	}
+
+	if _, ok := field(); !ok {
+		return nil, 0
+	}
+
+	if len(block) < 16 {
+		return nil, 0
+	}
+	block = block[16:]
+
+	data, ok := field()
+	if !ok || len(data) == 0 {
+		return nil, 0
+	}
+
+	return &Picture{MIMEType: normalizeImageMIME(string(mimeType), data), Data: data}, pictureType
+}
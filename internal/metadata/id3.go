@@ -0,0 +1,406 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+type id3Frame struct {
+	id   string
+	data []byte
+}
+
+func readID3File(r io.ReadSeeker, size int64, md *Metadata) error {
+	end, err := readID3v2(r, md)
+	if err != nil {
+		return err
+	}
+
+	// FLAC files are sometimes prefixed with an ID3v2 tag by taggers that
+	// do not know better.
+	magic, err := readAt(r, end, 4)
+	if err == nil && string(magic) == "fLaC" {
+		return readFLACAt(r, end, md)
+	}
+
+	return readMP3(r, end, size, md)
+}
+
+func syncsafe(b []byte) int64 {
+	return int64(b[0]&0x7f)<<21 | int64(b[1]&0x7f)<<14 | int64(b[2]&0x7f)<<7 | int64(b[3]&0x7f)
+}
+
+func removeUnsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// readID3v2 parses the ID3v2 tag at the start of r and returns the offset of
+// the first byte after it.
+func readID3v2(r io.ReadSeeker, md *Metadata) (int64, error) {
+	header, err := readAt(r, 0, 10)
+	if err != nil {
+		return 0, err
+	}
+
+	major := header[3]
+	flags := header[5]
+	size := syncsafe(header[6:10])
+	end := 10 + size
+	if flags&0x10 != 0 {
+		end += 10
+	}
+
+	if major < 2 || major > 4 {
+		return end, nil
+	}
+
+	body, err := readAt(r, 10, size)
+	if err != nil {
+		return 0, err
+	}
+
+	if flags&0x80 != 0 && major < 4 {
+		body = removeUnsync(body)
+	}
+
+	if flags&0x40 != 0 && major > 2 && len(body) >= 4 {
+		var skip int64
+		if major == 3 {
+			skip = int64(binary.BigEndian.Uint32(body[:4])) + 4
+		} else {
+			skip = syncsafe(body[:4])
+		}
+		if skip > int64(len(body)) {
+			return 0, ErrMalformed
+		}
+		body = body[skip:]
+	}
+
+	var (
+		pictures []*Picture
+		front    *Picture
+	)
+
+	for _, frame := range id3Frames(body, major) {
+		switch frame.id {
+		case "TIT2", "TT2":
+			setText(&md.Title, id3Text(frame.data))
+		case "TPE1", "TP1":
+			setText(&md.Artist, id3Text(frame.data))
+		case "TALB", "TAL":
+			setText(&md.Album, id3Text(frame.data))
+		case "TRCK", "TRK":
+			setNumber(&md.Track, parseNumber(id3Text(frame.data)))
+		case "TYER", "TYE", "TDRC", "TDRL", "TDOR":
+			setNumber(&md.Year, parseNumber(id3Text(frame.data)))
+		case "TCON", "TCO":
+			setText(&md.Genre, id3Genre(id3Text(frame.data)))
+		case "TLEN", "TLE":
+			ms := parseNumber(id3Text(frame.data))
+			if md.Duration == 0 && ms > 0 {
+				md.Duration = time.Duration(ms) * time.Millisecond
+			}
+		case "APIC", "PIC":
+			picture, pictureType := id3Picture(frame)
+			if picture == nil {
+				continue
+			}
+			pictures = append(pictures, picture)
+			if pictureType == 3 && front == nil {
+				front = picture
+			}
+		}
+	}
+
+	switch {
+	case front != nil:
+		md.Picture = front
+	case len(pictures) > 0:
+		md.Picture = pictures[0]
+	}
+
+	return end, nil
+}
+
+func id3Frames(body []byte, major byte) []id3Frame {
+	idLen, headerLen := 4, 10
+	if major == 2 {
+		idLen, headerLen = 3, 6
+	}
+
+	var frames []id3Frame
+
+	for len(body) >= headerLen {
+		if body[0] == 0 {
+			break
+		}
+
+		id := string(body[:idLen])
+
+		var size int
+		switch major {
+		case 2:
+			size = int(body[3])<<16 | int(body[4])<<8 | int(body[5])
+		case 3:
+			size = int(binary.BigEndian.Uint32(body[4:8]))
+		default:
+			size = int(syncsafe(body[4:8]))
+		}
+
+		if size < 0 || size > len(body)-headerLen {
+			break
+		}
+
+		var formatFlags byte
+		if major > 2 {
+			formatFlags = body[9]
+		}
+
+		data := body[headerLen : headerLen+size]
+		body = body[headerLen+size:]
+
+		switch major {
+		case 3:
+			if formatFlags&0xc0 != 0 {
+				continue
+			}
+			if formatFlags&0x20 != 0 && len(data) > 0 {
+				data = data[1:]
+			}
+		case 4:
+			if formatFlags&0x0c != 0 {
+				continue
+			}
+			if formatFlags&0x40 != 0 && len(data) > 0 {
+				data = data[1:]
+			}
+			if formatFlags&0x01 != 0 && len(data) >= 4 {
+				data = data[4:]
+			}
+			if formatFlags&0x02 != 0 {
+				data = removeUnsync(data)
+			}
+		}
+
+		frames = append(frames, id3Frame{id: id, data: data})
+	}
+
+	return frames
+}
+
+// id3Text decodes the first value of a text frame.
+func id3Text(data []byte) string {
+	if len(data) < 1 {
+		return ""
+	}
+
+	text, _ := id3String(data[0], data[1:])
+	return text
+}
+
+// id3String decodes a terminated string in the given ID3 encoding and
+// returns it with whatever follows the terminator.
+func id3String(encoding byte, data []byte) (string, []byte) {
+	switch encoding {
+	case 1, 2:
+		end := len(data)
+		rest := []byte(nil)
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				end = i
+				rest = data[i+2:]
+				break
+			}
+		}
+		return decodeUTF16(data[:end], encoding == 2), rest
+
+	default:
+		end := bytes.IndexByte(data, 0)
+		rest := []byte(nil)
+		if end < 0 {
+			end = len(data)
+		} else {
+			rest = data[end+1:]
+		}
+		if encoding == 3 {
+			return string(data[:end]), rest
+		}
+		return decodeLatin1(data[:end]), rest
+	}
+}
+
+func decodeLatin1(b []byte) string {
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes)
+}
+
+func decodeUTF16(b []byte, bigEndian bool) string {
+	if len(b) >= 2 {
+		switch {
+		case b[0] == 0xff && b[1] == 0xfe:
+			bigEndian = false
+			b = b[2:]
+		case b[0] == 0xfe && b[1] == 0xff:
+			bigEndian = true
+			b = b[2:]
+		}
+	}
+
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		if bigEndian {
+			units[i] = binary.BigEndian.Uint16(b[2*i:])
+		} else {
+			units[i] = binary.LittleEndian.Uint16(b[2*i:])
+		}
+	}
+
+	return string(utf16.Decode(units))
+}
+
+func id3Picture(frame id3Frame) (*Picture, byte) {
+	data := frame.data
+	if len(data) < 2 {
+		return nil, 0
+	}
+
+	encoding := data[0]
+	data = data[1:]
+
+	var mimeType string
+	if frame.id == "PIC" {
+		if len(data) < 3 {
+			return nil, 0
+		}
+		switch strings.ToUpper(string(data[:3])) {
+		case "PNG":
+			mimeType = "image/png"
+		default:
+			mimeType = "image/jpeg"
+		}
+		data = data[3:]
+	} else {
+		mimeType, data = id3String(0, data)
+	}
+
+	if len(data) < 1 {
+		return nil, 0
+	}
+	pictureType := data[0]
+
+	_, data = id3String(encoding, data[1:])
+	if len(data) == 0 {
+		return nil, 0
+	}
+
+	return &Picture{MIMEType: normalizeImageMIME(mimeType, data), Data: data}, pictureType
+}
+
+func normalizeImageMIME(mimeType string, data []byte) string {
+	mimeType = strings.ToLower(strings.TrimSpace(mimeType))
+
+	switch {
+	case bytes.HasPrefix(data, []byte("\x89PNG")):
+		return "image/png"
+	case bytes.HasPrefix(data, []byte{0xff, 0xd8}):
+		return "image/jpeg"
+	case mimeType == "jpg" || mimeType == "image/jpg":
+		return "image/jpeg"
+	case mimeType == "png":
+		return "image/png"
+	case strings.HasPrefix(mimeType, "image/"):
+		return mimeType
+	default:
+		return "image/jpeg"
+	}
+}
+
+// id3Genre resolves ID3v1 style numeric references such as "(17)" or "17"
+// to their genre names.
+func id3Genre(value string) string {
+	value = strings.TrimSpace(value)
+
+	if strings.HasPrefix(value, "(") {
+		end := strings.IndexByte(value, ')')
+		if end > 0 {
+			if rest := strings.TrimSpace(value[end+1:]); rest != "" {
+				return rest
+			}
+			value = value[1:end]
+		}
+	}
+
+	n, err := strconv.Atoi(value)
+	if err == nil {
+		if n >= 0 && n < len(id3v1Genres) {
+			return id3v1Genres[n]
+		}
+		return ""
+	}
+
+	return value
+}
+
+func readID3v1(r io.ReadSeeker, size int64, md *Metadata) (bool, error) {
+	if size < 128 {
+		return false, nil
+	}
+
+	tag, err := readAt(r, size-128, 128)
+	if err != nil {
+		return false, err
+	}
+
+	if string(tag[:3]) != "TAG" {
+		return false, nil
+	}
+
+	field := func(b []byte) string {
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			b = b[:i]
+		}
+		return strings.TrimSpace(decodeLatin1(b))
+	}
+
+	setText(&md.Title, field(tag[3:33]))
+	setText(&md.Artist, field(tag[33:63]))
+	setText(&md.Album, field(tag[63:93]))
+	setNumber(&md.Year, parseNumber(field(tag[93:97])))
+
+	if tag[125] == 0 && tag[126] != 0 {
+		setNumber(&md.Track, int(tag[126]))
+	}
+
+	if int(tag[127]) < len(id3v1Genres) {
+		setText(&md.Genre, id3v1Genres[tag[127]])
+	}
+
+	return true, nil
+}
+
+var id3v1Genres = []string{
+	"Blues", "Classic Rock", "Country", "Dance", "Disco", "Funk", "Grunge", "Hip-Hop",
+	"Jazz", "Metal", "New Age", "Oldies", "Other", "Pop", "R&B", "Rap",
+	"Reggae", "Rock", "Techno", "Industrial", "Alternative", "Ska", "Death Metal", "Pranks",
+	"Soundtrack", "Euro-Techno", "Ambient", "Trip-Hop", "Vocal", "Jazz+Funk", "Fusion", "Trance",
+	"Classical", "Instrumental", "Acid", "House", "Game", "Sound Clip", "Gospel", "Noise",
+	"AlternRock", "Bass", "Soul", "Punk", "Space", "Meditative", "Instrumental Pop", "Instrumental Rock",
+	"Ethnic", "Gothic", "Darkwave", "Techno-Industrial", "Electronic", "Pop-Folk", "Eurodance", "Dream",
+	"Southern Rock", "Comedy", "Cult", "Gangsta", "Top 40", "Christian Rap", "Pop/Funk", "Jungle",
+	"Native American", "Cabaret", "New Wave", "Psychadelic", "Rave", "Showtunes", "Trailer", "Lo-Fi",
+	"Tribal", "Acid Punk", "Acid Jazz", "Polka", "Retro", "Musical", "Rock & Roll", "Hard Rock",
+	"Folk", "Folk-Rock", "National Folk", "Swing", "Fast Fusion", "Bebob", "Latin", "Revival",
+	"Celtic", "Bluegrass", "Avantgarde", "Gothic Rock", "Progressive Rock", "Psychedelic Rock", "Symphonic Rock", "Slow Rock",
+	"Big Band", "Chorus", "Easy Listening", "Acoustic", "Humour", "Speech", "Chanson", "Opera",
+	"Chamber Music", "Sonata", "Symphony", "Booty Bass", "Primus", "Porn Groove", "Satire", "Slow Jam",
+	"Club", "Tango", "Samba", "Folklore", "Ballad", "Power Ballad", "Rhythmic Soul", "Freestyle",
+	"Duet", "Punk Rock", "Drum Solo", "A capella", "Euro-House", "Dance Hall",
+}
@@ -0,0 +1,194 @@
+package metadata
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported audio format")
+	ErrMalformed         = errors.New("malformed audio file")
+)
+
+// maxBlockSize bounds any single tag, block or atom read into memory so a
+// corrupt length field cannot exhaust the server.
+const maxBlockSize = 32 << 20
+
+const (
+	FormatMP3  = "mp3"
+	FormatFLAC = "flac"
+	FormatOGG  = "ogg"
+	FormatM4A  = "m4a"
+)
+
+type Picture struct {
+	MIMEType string
+	Data     []byte
+}
+
+type Metadata struct {
+	Format   string
+	Title    string
+	Artist   string
+	Album    string
+	Track    int
+	Year     int
+	Genre    string
+	Duration time.Duration
+	Picture  *Picture
+}
+
+// Read sniffs the container format of r and extracts its embedded tags.
+// MP3 (ID3v1/ID3v2), FLAC, Ogg Vorbis/Opus and MP4/M4A are supported.
+func Read(r io.ReadSeeker) (*Metadata, error) {
+	size, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, 12)
+	n, err := io.ReadFull(r, header)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return nil, ErrUnsupportedFormat
+	}
+	header = header[:n]
+
+	_, err = r.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	md := &Metadata{}
+
+	switch {
+	case bytes.HasPrefix(header, []byte("ID3")):
+		err = readID3File(r, size, md)
+	case bytes.HasPrefix(header, []byte("fLaC")):
+		err = readFLAC(r, md)
+	case bytes.HasPrefix(header, []byte("OggS")):
+		err = readOgg(r, size, md)
+	case len(header) >= 8 && string(header[4:8]) == "ftyp":
+		err = readMP4(r, size, md)
+	case len(header) >= 4 && isFrameSync(header):
+		err = readMP3(r, 0, size, md)
+	default:
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	md.Title = strings.TrimSpace(md.Title)
+	md.Artist = strings.TrimSpace(md.Artist)
+	md.Album = strings.TrimSpace(md.Album)
+	md.Genre = strings.TrimSpace(md.Genre)
+
+	return md, nil
+}
+
+func readAt(r io.ReadSeeker, offset int64, n int64) ([]byte, error) {
+	if n < 0 || n > maxBlockSize {
+		return nil, ErrMalformed
+	}
+
+	_, err := r.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrMalformed
+		}
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// parseNumber reads the leading integer of values such as "3/12" or
+// "2004-05-01", returning 0 when there is none.
+func parseNumber(s string) int {
+	s = strings.TrimSpace(s)
+
+	end := 0
+	for end < len(s) && s[end] >= '0' && s[end] <= '9' {
+		end++
+	}
+
+	n, err := strconv.Atoi(s[:end])
+	if err != nil {
+		return 0
+	}
+	return n
+}
+
+// setText fills an empty field so that the first tag found wins.
+func setText(dst *string, value string) {
+	value = strings.TrimRight(value, "\x00")
+	if *dst == "" && strings.TrimSpace(value) != "" {
+		*dst = value
+	}
+}
+
+func setNumber(dst *int, value int) {
+	if *dst == 0 && value > 0 {
+		*dst = value
+	}
+}
+
+func applyVorbisComment(md *Metadata, key string, value string) {
+	switch strings.ToUpper(key) {
+	case "TITLE":
+		setText(&md.Title, value)
+	case "ARTIST":
+		setText(&md.Artist, value)
+	case "ALBUM":
+		setText(&md.Album, value)
+	case "TRACKNUMBER":
+		setNumber(&md.Track, parseNumber(value))
+	case "DATE", "YEAR":
+		setNumber(&md.Year, parseNumber(value))
+	case "GENRE":
+		setText(&md.Genre, value)
+	}
+}
+
+func (md *Metadata) ContentType() string {
+	switch md.Format {
+	case FormatMP3:
+		return "audio/mpeg"
+	case FormatFLAC:
+		return "audio/flac"
+	case FormatOGG:
+		return "audio/ogg"
+	case FormatM4A:
+		return "audio/mp4"
+	default:
+		return "application/octet-stream"
+	}
+}
+
+func (p *Picture) Extension() string {
+	switch p.MIMEType {
+	case "image/png":
+		return ".png"
+	case "image/gif":
+		return ".gif"
+	case "image/webp":
+		return ".webp"
+	default:
+		return ".jpg"
+	}
+}
@@ -0,0 +1,365 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var (
+	testPNG  = []byte("\x89PNG\r\n\x1a\nnot really an image")
+	testJPEG = []byte("\xff\xd8\xff\xe0not really an image")
+)
+
+func be32(n uint32) []byte {
+	return binary.BigEndian.AppendUint32(nil, n)
+}
+
+func le32(n uint32) []byte {
+	return binary.LittleEndian.AppendUint32(nil, n)
+}
+
+func join(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// id3v2 builds an ID3v2.3 tag from frames made with id3v2Frame.
+func id3v2(frames ...[]byte) []byte {
+	body := join(frames...)
+	size := len(body)
+
+	header := []byte{'I', 'D', '3', 3, 0, 0,
+		byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}
+
+	return join(header, body)
+}
+
+func id3v2Frame(id string, data []byte) []byte {
+	return join([]byte(id), be32(uint32(len(data))), []byte{0, 0}, data)
+}
+
+func id3v2Text(id string, text string) []byte {
+	return id3v2Frame(id, join([]byte{0}, []byte(text)))
+}
+
+// id3v1 builds the 128 byte ID3v1.1 tag found at the end of an MP3.
+func id3v1(title, artist, album, year string, track byte, genre byte) []byte {
+	field := func(s string, n int) []byte {
+		b := make([]byte, n)
+		copy(b, s)
+		return b
+	}
+
+	return join([]byte("TAG"), field(title, 30), field(artist, 30), field(album, 30), field(year, 4),
+		field("", 28), []byte{0, track, genre})
+}
+
+// mpegFrames returns n bytes of MPEG-1 Layer III audio at 128 kbps and
+// 44.1 kHz. With xingFrames set the first frame carries a Xing header.
+func mpegFrames(n int, xingFrames uint32) []byte {
+	b := make([]byte, n)
+	copy(b, []byte{0xff, 0xfb, 0x90, 0x00})
+
+	if xingFrames > 0 {
+		copy(b[36:], join([]byte("Xing"), be32(1), be32(xingFrames)))
+	}
+
+	return b
+}
+
+func flacBlock(blockType byte, last bool, data []byte) []byte {
+	if last {
+		blockType |= 0x80
+	}
+	n := len(data)
+	return join([]byte{blockType, byte(n >> 16), byte(n >> 8), byte(n)}, data)
+}
+
+func flacStreamInfo(sampleRate uint32, totalSamples uint32) []byte {
+	b := make([]byte, 34)
+	b[10] = byte(sampleRate >> 12)
+	b[11] = byte(sampleRate >> 4)
+	b[12] = byte(sampleRate << 4)
+	binary.BigEndian.PutUint32(b[14:], totalSamples)
+	return b
+}
+
+func vorbisComment(comments ...string) []byte {
+	b := join(le32(6), []byte("apollo"), le32(uint32(len(comments))))
+	for _, comment := range comments {
+		b = join(b, le32(uint32(len(comment))), []byte(comment))
+	}
+	return b
+}
+
+func flacPicture(pictureType uint32, mimeType string, data []byte) []byte {
+	return join(be32(pictureType), be32(uint32(len(mimeType))), []byte(mimeType), be32(0),
+		make([]byte, 16), be32(uint32(len(data))), data)
+}
+
+// testOggPage builds a page holding whole packets, each shorter than 255 bytes.
+func testOggPage(granule uint64, packets ...[]byte) []byte {
+	header := join([]byte("OggS"), []byte{0, 0}, binary.LittleEndian.AppendUint64(nil, granule),
+		le32(1), le32(0), le32(0), []byte{byte(len(packets))})
+
+	var segments []byte
+	for _, packet := range packets {
+		segments = append(segments, byte(len(packet)))
+	}
+
+	return join(header, segments, join(packets...))
+}
+
+func vorbisIdent(sampleRate uint32) []byte {
+	return join([]byte("\x01vorbis"), le32(0), []byte{2}, le32(sampleRate), make([]byte, 14))
+}
+
+func opusHead(preSkip uint16) []byte {
+	return join([]byte("OpusHead"), []byte{1, 2}, binary.LittleEndian.AppendUint16(nil, preSkip), le32(48000), make([]byte, 3))
+}
+
+func atom(kind string, children ...[]byte) []byte {
+	body := join(children...)
+	return join(be32(uint32(8+len(body))), []byte(kind), body)
+}
+
+func ilstItem(kind string, dataType uint32, value []byte) []byte {
+	return atom(kind, atom("data", be32(dataType), be32(0), value))
+}
+
+func mvhd(timescale uint32, duration uint32) []byte {
+	b := make([]byte, 100)
+	binary.BigEndian.PutUint32(b[12:], timescale)
+	binary.BigEndian.PutUint32(b[16:], duration)
+	return atom("mvhd", b)
+}
+
+var ftyp = atom("ftyp", []byte("M4A "), be32(0), []byte("M4A "))
+
+func m4a(items ...[]byte) []byte {
+	hdlr := atom("hdlr", make([]byte, 25))
+	meta := atom("meta", be32(0), hdlr, atom("ilst", items...))
+
+	return join(ftyp, atom("moov", mvhd(1000, 4500), atom("udta", meta)), atom("mdat", make([]byte, 16)))
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name string
+		file []byte
+		want *Metadata
+	}{
+		{
+			name: "id3v2 with xing header",
+			file: join(
+				id3v2(
+					id3v2Text("TIT2", "Title"),
+					id3v2Text("TPE1", "Artist"),
+					id3v2Text("TALB", "Album"),
+					id3v2Text("TRCK", "3/12"),
+					id3v2Text("TYER", "2004"),
+					id3v2Text("TCON", "(17)"),
+					id3v2Frame("APIC", join([]byte{0}, []byte("image/png\x00"), []byte{0}, []byte("back\x00"), testJPEG)),
+					id3v2Frame("APIC", join([]byte{0}, []byte("image/png\x00"), []byte{3}, []byte("front\x00"), testPNG)),
+				),
+				mpegFrames(512, 1225),
+			),
+			want: &Metadata{
+				Format:   FormatMP3,
+				Title:    "Title",
+				Artist:   "Artist",
+				Album:    "Album",
+				Track:    3,
+				Year:     2004,
+				Genre:    "Rock",
+				Duration: 32 * time.Second,
+				Picture:  &Picture{MIMEType: "image/png", Data: testPNG},
+			},
+		},
+		{
+			name: "id3v2 utf-16 text",
+			file: join(
+				id3v2(id3v2Frame("TIT2", []byte("\x01\xff\xfeT\x00i\x00t\x00l\x00e\x00\x00\x00"))),
+				mpegFrames(512, 1225),
+			),
+			want: &Metadata{Format: FormatMP3, Title: "Title", Duration: 32 * time.Second},
+		},
+		{
+			name: "id3v1 only with constant bitrate",
+			file: join(mpegFrames(16000, 0), id3v1("Title", "Artist", "Album", "1999", 7, 13)),
+			want: &Metadata{
+				Format:   FormatMP3,
+				Title:    "Title",
+				Artist:   "Artist",
+				Album:    "Album",
+				Track:    7,
+				Year:     1999,
+				Genre:    "Pop",
+				Duration: time.Second,
+			},
+		},
+		{
+			name: "id3v2 wins over id3v1",
+			file: join(
+				id3v2(id3v2Text("TIT2", "New")),
+				mpegFrames(512, 1225),
+				id3v1("Old", "Artist", "", "", 0, 255),
+			),
+			want: &Metadata{Format: FormatMP3, Title: "New", Artist: "Artist", Duration: 32 * time.Second},
+		},
+		{
+			name: "flac",
+			file: join(
+				[]byte("fLaC"),
+				flacBlock(0, false, flacStreamInfo(44100, 3*44100)),
+				flacBlock(1, false, make([]byte, 8)),
+				flacBlock(4, false, vorbisComment("TITLE=Title", "artist=Artist", "ALBUM=Album", "TRACKNUMBER=2", "DATE=2010-05-01", "GENRE=Jazz", "NOT A COMMENT")),
+				flacBlock(6, true, flacPicture(3, "image/jpeg", testJPEG)),
+			),
+			want: &Metadata{
+				Format:   FormatFLAC,
+				Title:    "Title",
+				Artist:   "Artist",
+				Album:    "Album",
+				Track:    2,
+				Year:     2010,
+				Genre:    "Jazz",
+				Duration: 3 * time.Second,
+				Picture:  &Picture{MIMEType: "image/jpeg", Data: testJPEG},
+			},
+		},
+		{
+			name: "flac behind an id3v2 tag",
+			file: join(
+				id3v2(id3v2Text("TIT2", "Tagged")),
+				[]byte("fLaC"),
+				flacBlock(0, true, flacStreamInfo(48000, 48000)),
+			),
+			want: &Metadata{Format: FormatFLAC, Title: "Tagged", Duration: time.Second},
+		},
+		{
+			name: "flac comment count beyond the block",
+			file: join(
+				[]byte("fLaC"),
+				flacBlock(4, true, join(le32(0), le32(1000), le32(11), []byte("TITLE=Title"), le32(0xffffffff))),
+			),
+			want: &Metadata{Format: FormatFLAC, Title: "Title"},
+		},
+		{
+			name: "ogg vorbis",
+			file: join(
+				testOggPage(0, vorbisIdent(48000)),
+				testOggPage(0, join([]byte("\x03vorbis"), vorbisComment("TITLE=Title", "ARTIST=Artist"))),
+				testOggPage(2*48000, make([]byte, 32)),
+			),
+			want: &Metadata{Format: FormatOGG, Title: "Title", Artist: "Artist", Duration: 2 * time.Second},
+		},
+		{
+			name: "ogg opus",
+			file: join(
+				testOggPage(0, opusHead(312), join([]byte("OpusTags"), vorbisComment("TITLE=Title", "YEAR=2021"))),
+				testOggPage(5*48000+312, make([]byte, 32)),
+			),
+			want: &Metadata{Format: FormatOGG, Title: "Title", Year: 2021, Duration: 5 * time.Second},
+		},
+		{
+			name: "m4a",
+			file: m4a(
+				ilstItem("\xa9nam", 1, []byte("Title")),
+				ilstItem("\xa9ART", 1, []byte("Artist")),
+				ilstItem("\xa9alb", 1, []byte("Album")),
+				ilstItem("\xa9day", 1, []byte("2015-01-01T00:00:00Z")),
+				ilstItem("gnre", 0, []byte{0, 10}),
+				ilstItem("trkn", 0, []byte{0, 0, 0, 4, 0, 10, 0, 0}),
+				ilstItem("covr", 14, testPNG),
+			),
+			want: &Metadata{
+				Format:   FormatM4A,
+				Title:    "Title",
+				Artist:   "Artist",
+				Album:    "Album",
+				Track:    4,
+				Year:     2015,
+				Genre:    "Metal",
+				Duration: 4500 * time.Millisecond,
+				Picture:  &Picture{MIMEType: "image/png", Data: testPNG},
+			},
+		},
+		{
+			name: "m4a item larger than its parent",
+			file: m4a(
+				ilstItem("\xa9nam", 1, []byte("Title")),
+				join(be32(1000), []byte("\xa9ART"), []byte("Artist")),
+			),
+			want: &Metadata{Format: FormatM4A, Title: "Title", Duration: 4500 * time.Millisecond},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			md, err := Read(bytes.NewReader(tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(md, tt.want) {
+				t.Errorf("metadata = %+v; want %+v", md, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	oversizedTag := []byte{'I', 'D', '3', 3, 0, 0, 0x7f, 0x7f, 0x7f, 0x7f}
+
+	tests := []struct {
+		name    string
+		file    []byte
+		wantErr error
+	}{
+		{name: "empty", file: nil, wantErr: ErrUnsupportedFormat},
+		{name: "unknown format", file: []byte("RIFF\x00\x00\x00\x00WAVEfmt "), wantErr: ErrUnsupportedFormat},
+		{name: "truncated id3v2 header", file: []byte("ID3\x03\x00"), wantErr: ErrMalformed},
+		{name: "id3v2 tag past the end of the file", file: join(id3v2(id3v2Text("TIT2", "Title")), make([]byte, 4))[:20], wantErr: ErrMalformed},
+		{name: "oversized id3v2 tag", file: join(oversizedTag, make([]byte, 64)), wantErr: ErrMalformed},
+		{name: "truncated flac block", file: join([]byte("fLaC"), flacBlock(0, true, flacStreamInfo(44100, 44100)))[:20], wantErr: ErrMalformed},
+		{name: "oversized flac block", file: join([]byte("fLaC"), []byte{0x84, 0xff, 0xff, 0xff}, vorbisComment("TITLE=Title")), wantErr: ErrMalformed},
+		{name: "flac without a last block", file: join([]byte("fLaC"), flacBlock(0, false, flacStreamInfo(44100, 44100))), wantErr: ErrMalformed},
+		{name: "truncated ogg page", file: testOggPage(0, vorbisIdent(48000))[:40], wantErr: ErrMalformed},
+		{name: "ogg missing comment packet", file: testOggPage(0, vorbisIdent(48000)), wantErr: ErrMalformed},
+		{name: "ogg unknown codec", file: join(testOggPage(0, []byte("\x80theora")), testOggPage(0, []byte("\x81theora"))), wantErr: ErrUnsupportedFormat},
+		{name: "ogg page with a bad capture pattern", file: join(testOggPage(0, vorbisIdent(48000)), []byte("OggX"), make([]byte, 40)), wantErr: ErrMalformed},
+		{name: "m4a atom past the end of the file", file: join(ftyp, be32(1000), []byte("moov"), make([]byte, 16)), wantErr: ErrMalformed},
+		{name: "m4a atom smaller than its header", file: join(ftyp, be32(4), []byte("moov"), make([]byte, 16)), wantErr: ErrMalformed},
+		{name: "oversized 64-bit m4a atom", file: join(ftyp, be32(1), []byte("moov"), binary.BigEndian.AppendUint64(nil, 1<<62), make([]byte, 16)), wantErr: ErrMalformed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(tt.file))
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// FuzzRead checks that no input makes Read panic or hang, and that it either
+// fails or returns metadata.
+func FuzzRead(f *testing.F) {
+	f.Add(join(id3v2(id3v2Text("TIT2", "Title"), id3v2Frame("APIC", join([]byte{0}, []byte("image/png\x00"), []byte{3, 0}, testPNG))), mpegFrames(128, 10)))
+	f.Add(join(mpegFrames(512, 0), id3v1("Title", "Artist", "Album", "1999", 7, 13)))
+	f.Add(join([]byte("fLaC"), flacBlock(0, false, flacStreamInfo(44100, 44100)), flacBlock(4, false, vorbisComment("TITLE=Title")), flacBlock(6, true, flacPicture(3, "image/png", testPNG))))
+	f.Add(join(testOggPage(0, vorbisIdent(48000)), testOggPage(0, join([]byte("\x03vorbis"), vorbisComment("TITLE=Title"))), testOggPage(48000, make([]byte, 8))))
+	f.Add(join(testOggPage(0, opusHead(312), join([]byte("OpusTags"), vorbisComment("TITLE=Title"))), testOggPage(48000, make([]byte, 8))))
+	f.Add(m4a(ilstItem("\xa9nam", 1, []byte("Title")), ilstItem("covr", 13, testJPEG)))
+
+	f.Fuzz(func(t *testing.T, file []byte) {
+		md, err := Read(bytes.NewReader(file))
+		if err == nil && md == nil {
+			t.Fatal("no error and no metadata")
+		}
+	})
+}
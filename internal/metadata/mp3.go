@@ -0,0 +1,169 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+var mpegBitrates = map[[2]int][]int{
+	{1, 1}: {0, 32, 64, 96, 128, 160, 192, 224, 256, 288, 320, 352, 384, 416, 448},
+	{1, 2}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 384},
+	{1, 3}: {0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320},
+	{2, 1}: {0, 32, 48, 56, 64, 80, 96, 112, 128, 144, 160, 176, 192, 224, 256},
+	{2, 2}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+	{2, 3}: {0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160},
+}
+
+var mpegSampleRates = map[int][]int{
+	1:  {44100, 48000, 32000},
+	2:  {22050, 24000, 16000},
+	25: {11025, 12000, 8000},
+}
+
+type mpegFrame struct {
+	version    int
+	layer      int
+	bitrate    int
+	sampleRate int
+	mono       bool
+}
+
+func isFrameSync(b []byte) bool {
+	_, ok := parseFrameHeader(b)
+	return ok
+}
+
+func parseFrameHeader(b []byte) (mpegFrame, bool) {
+	if len(b) < 4 || b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return mpegFrame{}, false
+	}
+
+	var frame mpegFrame
+
+	switch (b[1] >> 3) & 0x03 {
+	case 0:
+		frame.version = 25
+	case 2:
+		frame.version = 2
+	case 3:
+		frame.version = 1
+	default:
+		return mpegFrame{}, false
+	}
+
+	layer := int((b[1] >> 1) & 0x03)
+	if layer == 0 {
+		return mpegFrame{}, false
+	}
+	frame.layer = 4 - layer
+
+	bitrateIndex := int(b[2] >> 4)
+	sampleRateIndex := int((b[2] >> 2) & 0x03)
+	if bitrateIndex == 0 || bitrateIndex == 15 || sampleRateIndex == 3 {
+		return mpegFrame{}, false
+	}
+
+	tableVersion := frame.version
+	if tableVersion == 25 {
+		tableVersion = 2
+	}
+
+	frame.bitrate = mpegBitrates[[2]int{tableVersion, frame.layer}][bitrateIndex] * 1000
+	frame.sampleRate = mpegSampleRates[frame.version][sampleRateIndex]
+	frame.mono = (b[3]>>6)&0x03 == 3
+
+	return frame, true
+}
+
+func (f mpegFrame) samplesPerFrame() int {
+	switch {
+	case f.layer == 1:
+		return 384
+	case f.layer == 3 && f.version != 1:
+		return 576
+	default:
+		return 1152
+	}
+}
+
+func (f mpegFrame) sideInfoSize() int {
+	switch {
+	case f.version == 1 && f.mono:
+		return 17
+	case f.version == 1:
+		return 32
+	case f.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+func readMP3(r io.ReadSeeker, start int64, size int64, md *Metadata) error {
+	md.Format = FormatMP3
+
+	end := size
+	hasV1, err := readID3v1(r, size, md)
+	if err != nil {
+		return err
+	}
+	if hasV1 {
+		end -= 128
+	}
+
+	if md.Duration > 0 || end <= start {
+		return nil
+	}
+
+	window := min(end-start, 64<<10)
+	buf, err := readAt(r, start, window)
+	if err != nil {
+		return err
+	}
+
+	for i := 0; i+4 <= len(buf); i++ {
+		frame, ok := parseFrameHeader(buf[i:])
+		if !ok {
+			continue
+		}
+
+		md.Duration = mpegDuration(frame, buf[i:], end-start-int64(i))
+		return nil
+	}
+
+	return nil
+}
+
+// mpegDuration prefers the frame count from a Xing/Info or VBRI header and
+// falls back to a constant bitrate estimate.
+func mpegDuration(frame mpegFrame, data []byte, audioBytes int64) time.Duration {
+	var frames int64
+
+	xing := 4 + frame.sideInfoSize()
+	if len(data) >= xing+12 {
+		tag := data[xing : xing+4]
+		if bytes.Equal(tag, []byte("Xing")) || bytes.Equal(tag, []byte("Info")) {
+			flags := binary.BigEndian.Uint32(data[xing+4:])
+			if flags&0x01 != 0 {
+				frames = int64(binary.BigEndian.Uint32(data[xing+8:]))
+			}
+		}
+	}
+
+	if frames == 0 && len(data) >= 36+18 && bytes.Equal(data[36:40], []byte("VBRI")) {
+		frames = int64(binary.BigEndian.Uint32(data[36+14:]))
+	}
+
+	if frames > 0 {
+		samples := frames * int64(frame.samplesPerFrame())
+		return time.Duration(samples) * time.Second / time.Duration(frame.sampleRate)
+	}
+
+	if frame.bitrate == 0 {
+		return 0
+	}
+
+	return time.Duration(audioBytes*8) * time.Second / time.Duration(frame.bitrate)
+}
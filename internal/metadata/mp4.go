@@ -0,0 +1,206 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+)
+
+type mp4Atom struct {
+	kind string
+	data []byte
+}
+
+// mp4Atoms splits a buffer into its child atoms.
+func mp4Atoms(b []byte) []mp4Atom {
+	var atoms []mp4Atom
+
+	for len(b) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(b))
+		kind := string(b[4:8])
+		headerLen := uint64(8)
+
+		switch size {
+		case 0:
+			size = uint64(len(b))
+		case 1:
+			if len(b) < 16 {
+				return atoms
+			}
+			size = binary.BigEndian.Uint64(b[8:])
+			headerLen = 16
+		}
+
+		if size < headerLen || size > uint64(len(b)) {
+			return atoms
+		}
+
+		atoms = append(atoms, mp4Atom{kind: kind, data: b[headerLen:size]})
+		b = b[size:]
+	}
+
+	return atoms
+}
+
+func findAtom(atoms []mp4Atom, kind string) (mp4Atom, bool) {
+	for _, atom := range atoms {
+		if atom.kind == kind {
+			return atom, true
+		}
+	}
+	return mp4Atom{}, false
+}
+
+func readMP4(r io.ReadSeeker, size int64, md *Metadata) error {
+	md.Format = FormatM4A
+
+	var offset int64
+
+	for offset+8 <= size {
+		header, err := readAt(r, offset, 16)
+		if err != nil {
+			header, err = readAt(r, offset, 8)
+			if err != nil {
+				return err
+			}
+		}
+
+		atomSize := int64(binary.BigEndian.Uint32(header))
+		headerLen := int64(8)
+
+		switch atomSize {
+		case 0:
+			atomSize = size - offset
+		case 1:
+			if len(header) < 16 {
+				return ErrMalformed
+			}
+			atomSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerLen = 16
+		}
+
+		if atomSize < headerLen || offset+atomSize > size {
+			return ErrMalformed
+		}
+
+		if string(header[4:8]) == "moov" {
+			moov, err := readAt(r, offset+headerLen, atomSize-headerLen)
+			if err != nil {
+				return err
+			}
+			readMoov(mp4Atoms(moov), md)
+			return nil
+		}
+
+		offset += atomSize
+	}
+
+	return nil
+}
+
+func readMoov(moov []mp4Atom, md *Metadata) {
+	if mvhd, ok := findAtom(moov, "mvhd"); ok {
+		md.Duration = mvhdDuration(mvhd.data)
+	}
+
+	udta, ok := findAtom(moov, "udta")
+	if !ok {
+		return
+	}
+
+	meta, ok := findAtom(mp4Atoms(udta.data), "meta")
+	if !ok {
+		return
+	}
+
+	// ISO meta boxes carry a version and flags word, QuickTime ones do not.
+	body := meta.data
+	if len(body) >= 8 && string(body[4:8]) != "hdlr" {
+		body = body[4:]
+	}
+
+	ilst, ok := findAtom(mp4Atoms(body), "ilst")
+	if !ok {
+		return
+	}
+
+	for _, item := range mp4Atoms(ilst.data) {
+		value, dataType, ok := ilstValue(item.data)
+		if !ok {
+			continue
+		}
+
+		switch item.kind {
+		case "\xa9nam":
+			setText(&md.Title, string(value))
+		case "\xa9ART", "aART":
+			setText(&md.Artist, string(value))
+		case "\xa9alb":
+			setText(&md.Album, string(value))
+		case "\xa9day":
+			setNumber(&md.Year, parseNumber(string(value)))
+		case "\xa9gen":
+			setText(&md.Genre, string(value))
+		case "gnre":
+			if len(value) >= 2 {
+				index := int(binary.BigEndian.Uint16(value)) - 1
+				if index >= 0 && index < len(id3v1Genres) {
+					setText(&md.Genre, id3v1Genres[index])
+				}
+			}
+		case "trkn":
+			if len(value) >= 4 {
+				setNumber(&md.Track, int(binary.BigEndian.Uint16(value[2:4])))
+			}
+		case "covr":
+			if md.Picture == nil && len(value) > 0 {
+				mimeType := "image/jpeg"
+				if dataType == 14 {
+					mimeType = "image/png"
+				}
+				md.Picture = &Picture{MIMEType: normalizeImageMIME(mimeType, value), Data: value}
+			}
+		}
+	}
+}
+
+func mvhdDuration(b []byte) time.Duration {
+	if len(b) < 1 {
+		return 0
+	}
+
+	var timescale, duration uint64
+
+	switch b[0] {
+	case 1:
+		if len(b) < 32 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[20:24]))
+		duration = binary.BigEndian.Uint64(b[24:32])
+	default:
+		if len(b) < 20 {
+			return 0
+		}
+		timescale = uint64(binary.BigEndian.Uint32(b[12:16]))
+		duration = uint64(binary.BigEndian.Uint32(b[16:20]))
+	}
+
+	if timescale == 0 {
+		return 0
+	}
+
+	return time.Duration(duration) * time.Second / time.Duration(timescale)
+}
+
+// ilstValue unwraps the "data" atom of a metadata item, returning its
+// payload and well-known type indicator.
+func ilstValue(b []byte) ([]byte, uint32, bool) {
+	data, ok := findAtom(mp4Atoms(b), "data")
+	if !ok || len(data.data) < 8 {
+		return nil, 0, false
+	}
+
+	dataType := binary.BigEndian.Uint32(data.data) & 0x00ffffff
+	return data.data[8:], dataType, true
+}
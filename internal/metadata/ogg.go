@@ -0,0 +1,182 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"strings"
+	"time"
+)
+
+type oggPage struct {
+	headerType byte
+	granule    int64
+	serial     uint32
+	segments   []byte
+	body       []byte
+	size       int64
+}
+
+func readOggPage(r io.ReadSeeker, offset int64) (*oggPage, error) {
+	header, err := readAt(r, offset, 27)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(header[:4]) != "OggS" {
+		return nil, ErrMalformed
+	}
+
+	page := &oggPage{
+		headerType: header[5],
+		granule:    int64(binary.LittleEndian.Uint64(header[6:14])),
+		serial:     binary.LittleEndian.Uint32(header[14:18]),
+	}
+
+	page.segments, err = readAt(r, offset+27, int64(header[26]))
+	if err != nil {
+		return nil, err
+	}
+
+	var bodySize int64
+	for _, segment := range page.segments {
+		bodySize += int64(segment)
+	}
+
+	page.body, err = readAt(r, offset+27+int64(len(page.segments)), bodySize)
+	if err != nil {
+		return nil, err
+	}
+
+	page.size = 27 + int64(len(page.segments)) + bodySize
+
+	return page, nil
+}
+
+// readOggPackets reassembles the first n packets of the logical stream that
+// starts at offset 0.
+func readOggPackets(r io.ReadSeeker, n int) ([][]byte, error) {
+	var (
+		packets [][]byte
+		current []byte
+		offset  int64
+		serial  uint32
+		total   int
+	)
+
+	for first := true; len(packets) < n; first = false {
+		page, err := readOggPage(r, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset += page.size
+
+		if first {
+			serial = page.serial
+		}
+		if page.serial != serial {
+			continue
+		}
+
+		body := page.body
+		for _, segment := range page.segments {
+			current = append(current, body[:segment]...)
+			body = body[segment:]
+			total += int(segment)
+
+			if total > maxBlockSize {
+				return nil, ErrMalformed
+			}
+
+			if segment < 255 {
+				packets = append(packets, current)
+				current = nil
+				if len(packets) == n {
+					break
+				}
+			}
+		}
+	}
+
+	return packets, nil
+}
+
+func readOgg(r io.ReadSeeker, size int64, md *Metadata) error {
+	md.Format = FormatOGG
+
+	packets, err := readOggPackets(r, 2)
+	if err != nil {
+		return err
+	}
+
+	ident, comment := packets[0], packets[1]
+
+	var (
+		sampleRate int64
+		preSkip    int64
+	)
+
+	switch {
+	case len(ident) >= 16 && bytes.HasPrefix(ident, []byte("\x01vorbis")):
+		sampleRate = int64(binary.LittleEndian.Uint32(ident[12:16]))
+		if bytes.HasPrefix(comment, []byte("\x03vorbis")) {
+			readVorbisComment(comment[7:], md)
+		}
+
+	case len(ident) >= 12 && bytes.HasPrefix(ident, []byte("OpusHead")):
+		sampleRate = 48000
+		preSkip = int64(binary.LittleEndian.Uint16(ident[10:12]))
+		if bytes.HasPrefix(comment, []byte("OpusTags")) {
+			readVorbisComment(comment[8:], md)
+		}
+
+	default:
+		return ErrUnsupportedFormat
+	}
+
+	granule, err := lastGranule(r, size)
+	if err != nil {
+		return err
+	}
+
+	if sampleRate > 0 && granule > preSkip {
+		md.Duration = time.Duration(granule-preSkip) * time.Second / time.Duration(sampleRate)
+	}
+
+	return nil
+}
+
+// lastGranule returns the granule position of the final page, which for
+// audio streams is the total number of samples.
+func lastGranule(r io.ReadSeeker, size int64) (int64, error) {
+	window := min(size, 64<<10)
+
+	tail, err := readAt(r, size-window, window)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := bytes.LastIndex(tail, []byte("OggS")); i >= 0; i = bytes.LastIndex(tail[:i], []byte("OggS")) {
+		if len(tail)-i < 14 {
+			continue
+		}
+
+		granule := int64(binary.LittleEndian.Uint64(tail[i+6 : i+14]))
+		if granule > 0 {
+			return granule, nil
+		}
+	}
+
+	return 0, nil
+}
+
+func decodeBase64(s string) []byte {
+	s = strings.TrimSpace(s)
+
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil
+	}
+	return data
+}
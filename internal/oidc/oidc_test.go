@@ -0,0 +1,431 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientID    = "apollo"
+	testRedirectURL = "http://apollo.test/v1/oidc/callback"
+	testKeyID       = "test-key"
+)
+
+// mockIssuer is a minimal OpenID Connect provider: it serves discovery and
+// a JWKS, and its token endpoint checks PKCE before handing out the ID token
+// queued for a code.
+type mockIssuer struct {
+	t      *testing.T
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu     sync.Mutex
+	codes  map[string]mockCode
+	issuer string // overrides the issuer in the discovery document
+}
+
+type mockCode struct {
+	challenge string
+	idToken   string
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	t.Helper()
+
+	m := &mockIssuer{
+		t:     t,
+		key:   generateKey(t),
+		codes: make(map[string]mockCode),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", m.discovery)
+	mux.HandleFunc("GET /jwks", m.jwks)
+	mux.HandleFunc("POST /token", m.token)
+
+	m.server = httptest.NewServer(mux)
+	t.Cleanup(m.server.Close)
+
+	return m
+}
+
+func (m *mockIssuer) URL() string {
+	return m.server.URL
+}
+
+func (m *mockIssuer) provider() *Provider {
+	return New(m.server.Client(), m.URL(), testClientID, "", testRedirectURL)
+}
+
+func (m *mockIssuer) discovery(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	issuer := m.issuer
+	m.mu.Unlock()
+
+	if issuer == "" {
+		issuer = m.URL()
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 issuer,
+		"authorization_endpoint": m.URL() + "/authorize",
+		"token_endpoint":         m.URL() + "/token",
+		"jwks_uri":               m.URL() + "/jwks",
+	})
+}
+
+func (m *mockIssuer) jwks(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": testKeyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(m.key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(m.key.E)).Bytes()),
+		}},
+	})
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+
+	m.mu.Lock()
+	code, ok := m.codes[r.PostForm.Get("code")]
+	delete(m.codes, r.PostForm.Get("code"))
+	m.mu.Unlock()
+
+	switch {
+	case r.PostForm.Get("grant_type") != "authorization_code",
+		r.PostForm.Get("client_id") != testClientID,
+		r.PostForm.Get("redirect_uri") != testRedirectURL:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	case !ok, Challenge(r.PostForm.Get("code_verifier")) != code.challenge:
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"id_token": code.idToken, "token_type": "Bearer"})
+	}
+}
+
+// authorize does what the authorization endpoint would once the user logged
+// in: it issues a code bound to the PKCE challenge and an ID token.
+func (m *mockIssuer) authorize(challenge string, idToken string) string {
+	code, err := RandomString()
+	if err != nil {
+		m.t.Fatal(err)
+	}
+
+	m.mu.Lock()
+	m.codes[code] = mockCode{challenge: challenge, idToken: idToken}
+	m.mu.Unlock()
+
+	return code
+}
+
+// claims returns valid ID token claims for a login with nonce.
+func (m *mockIssuer) claims(nonce string) map[string]any {
+	now := time.Now()
+
+	return map[string]any{
+		"iss":            m.URL(),
+		"sub":            "user-1",
+		"aud":            testClientID,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          nonce,
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"name":           "Alice",
+	}
+}
+
+func (m *mockIssuer) sign(claims map[string]any) string {
+	return signToken(m.t, m.key, testKeyID, claims)
+}
+
+func generateKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return key
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, kid string, claims map[string]any) string {
+	t.Helper()
+
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": kid})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	digest := sha256.Sum256([]byte(signed))
+
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	authURL, err := issuer.provider().AuthCodeURL(context.Background(), "state", "nonce", Challenge("verifier"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := u.Scheme+"://"+u.Host+u.Path, issuer.URL()+"/authorize"; got != want {
+		t.Errorf("authorization endpoint = %q; want %q", got, want)
+	}
+
+	want := map[string]string{
+		"response_type":         "code",
+		"client_id":             testClientID,
+		"redirect_uri":          testRedirectURL,
+		"scope":                 strings.Join(Scopes, " "),
+		"state":                 "state",
+		"nonce":                 "nonce",
+		"code_challenge":        Challenge("verifier"),
+		"code_challenge_method": "S256",
+	}
+
+	for name, value := range want {
+		if got := u.Query().Get(name); got != value {
+			t.Errorf("%s = %q; want %q", name, got, value)
+		}
+	}
+}
+
+func TestDiscoveryIssuerMismatch(t *testing.T) {
+	issuer := newMockIssuer(t)
+	issuer.issuer = "https://evil.example.com"
+
+	_, err := issuer.provider().AuthCodeURL(context.Background(), "state", "nonce", Challenge("verifier"))
+	if !errors.Is(err, ErrDiscovery) {
+		t.Errorf("err = %v; want %v", err, ErrDiscovery)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	issuer := newMockIssuer(t)
+	provider := issuer.provider()
+
+	verifier, err := RandomString()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := issuer.authorize(Challenge(verifier), issuer.sign(issuer.claims("nonce")))
+
+	claims, err := provider.Exchange(context.Background(), code, verifier, "nonce")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := Claims{
+		Issuer:        issuer.URL(),
+		Subject:       "user-1",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Name:          "Alice",
+	}
+
+	if *claims != want {
+		t.Errorf("claims = %+v; want %+v", *claims, want)
+	}
+
+	_, err = provider.Exchange(context.Background(), code, verifier, "nonce")
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("replayed code: err = %v; want %v", err, ErrExchange)
+	}
+}
+
+func TestExchangeWrongVerifier(t *testing.T) {
+	issuer := newMockIssuer(t)
+
+	code := issuer.authorize(Challenge("verifier"), issuer.sign(issuer.claims("nonce")))
+
+	_, err := issuer.provider().Exchange(context.Background(), code, "another verifier", "nonce")
+	if !errors.Is(err, ErrExchange) {
+		t.Errorf("err = %v; want %v", err, ErrExchange)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	issuer := newMockIssuer(t)
+	otherKey := generateKey(t)
+
+	tests := []struct {
+		name    string
+		token   func() string
+		nonce   string
+		wantErr error
+	}{
+		{
+			name:  "valid",
+			token: func() string { return issuer.sign(issuer.claims("nonce")) },
+			nonce: "nonce",
+		},
+		{
+			name:  "audience list with azp",
+			nonce: "nonce",
+			token: func() string {
+				claims := issuer.claims("nonce")
+				claims["aud"] = []string{testClientID, "another-client"}
+				claims["azp"] = testClientID
+				return issuer.sign(claims)
+			},
+		},
+		{
+			name:    "bad signature",
+			nonce:   "nonce",
+			wantErr: ErrInvalidToken,
+			token: func() string {
+				return signToken(t, otherKey, testKeyID, issuer.claims("nonce"))
+			},
+		},
+		{
+			name:    "tampered payload",
+			nonce:   "nonce",
+			wantErr: ErrInvalidToken,
+			token: func() string {
+				parts := strings.Split(issuer.sign(issuer.claims("nonce")), ".")
+				claims := issuer.claims("nonce")
+				claims["sub"] = "user-2"
+				payload, _ := json.Marshal(claims)
+				return parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+			},
+		},
+		{
+			name:    "unknown key",
+			nonce:   "nonce",
+			wantErr: ErrUnknownSigner,
+			token: func() string {
+				return signToken(t, otherKey, "other-key", issuer.claims("nonce"))
+			},
+		},
+		{
+			name:    "unsigned",
+			nonce:   "nonce",
+			wantErr: ErrInvalidToken,
+			token: func() string {
+				header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none"}`))
+				payload, _ := json.Marshal(issuer.claims("nonce"))
+				return header + "." + base64.RawURLEncoding.EncodeToString(payload) + "."
+			},
+		},
+		{
+			name:    "wrong audience",
+			nonce:   "nonce",
+			wantErr: ErrInvalidToken,
+			token: func() string {
+				claims := issuer.claims("nonce")
+				claims["aud"] = "another-client"
+				return issuer.sign(claims)
+			},
+		},
+		{
+			name:    "audience list without azp",
+			nonce:   "nonce",
+			wantErr: ErrInvalidToken,
+			token: func() string {
+				claims := issuer.claims("nonce")
+				claims["aud"] = []string{testClientID, "another-client"}
+				return issuer.sign(claims)
+			},
+		},
+		{
+			name:    "wrong issuer",
+			nonce:   "nonce",
+			wantErr: ErrInvalidToken,
+			token: func() string {
+				claims := issuer.claims("nonce")
+				claims["iss"] = "https://evil.example.com"
+				return issuer.sign(claims)
+			},
+		},
+		{
+			name:    "expired",
+			nonce:   "nonce",
+			wantErr: ErrInvalidToken,
+			token: func() string {
+				claims := issuer.claims("nonce")
+				claims["exp"] = time.Now().Add(-leeway - time.Minute).Unix()
+				return issuer.sign(claims)
+			},
+		},
+		{
+			name:    "not valid yet",
+			nonce:   "nonce",
+			wantErr: ErrInvalidToken,
+			token: func() string {
+				claims := issuer.claims("nonce")
+				claims["nbf"] = time.Now().Add(leeway + time.Minute).Unix()
+				return issuer.sign(claims)
+			},
+		},
+		{
+			name:    "nonce mismatch",
+			nonce:   "another nonce",
+			wantErr: ErrInvalidToken,
+			token:   func() string { return issuer.sign(issuer.claims("nonce")) },
+		},
+	}
+
+	provider := issuer.provider()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := provider.Verify(context.Background(), tt.token(), tt.nonce)
+
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Errorf("err = %v; want nil", err)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr):
+				t.Errorf("err = %v; want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package playlistfile
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+type jsonPlaylist struct {
+	Title  string      `json:"title"`
+	Tracks []jsonTrack `json:"tracks"`
+}
+
+type jsonTrack struct {
+	Location    string `json:"location"`
+	Title       string `json:"title,omitempty"`
+	Artist      string `json:"artist,omitempty"`
+	Duration    int    `json:"duration,omitempty"`
+	ContentHash string `json:"content_hash,omitempty"`
+}
+
+func decodeJSON(r io.Reader) (*Playlist, error) {
+	var doc jsonPlaylist
+
+	err := json.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	p := &Playlist{Title: strings.TrimSpace(doc.Title), Entries: make([]Entry, 0, len(doc.Tracks))}
+
+	for _, track := range doc.Tracks {
+		entry := Entry{
+			Location:    strings.TrimSpace(track.Location),
+			Title:       strings.TrimSpace(track.Title),
+			Artist:      strings.TrimSpace(track.Artist),
+			ContentHash: strings.ToLower(strings.TrimSpace(track.ContentHash)),
+		}
+		if track.Duration > 0 {
+			entry.Duration = track.Duration
+		}
+
+		p.Entries = append(p.Entries, entry)
+	}
+
+	return p, nil
+}
+
+func encodeJSON(w io.Writer, p *Playlist) error {
+	doc := jsonPlaylist{
+		Title:  p.Title,
+		Tracks: make([]jsonTrack, 0, len(p.Entries)),
+	}
+
+	for _, entry := range p.Entries {
+		doc.Tracks = append(doc.Tracks, jsonTrack{
+			Location:    entry.Location,
+			Title:       entry.Title,
+			Artist:      entry.Artist,
+			Duration:    entry.Duration,
+			ContentHash: entry.ContentHash,
+		})
+	}
+
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "\t")
+
+	return encoder.Encode(doc)
+}
@@ -0,0 +1,79 @@
+package playlistfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+func decodeM3U8(r io.Reader) (*Playlist, error) {
+	p := &Playlist{Entries: []Entry{}}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var pending Entry
+	lineNumber := 0
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if lineNumber == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXTINF:"):
+			// #EXTINF:<seconds>[ attributes],<display title>
+			info, display, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			seconds, _, _ := strings.Cut(info, " ")
+
+			pending = Entry{}
+			if duration, err := strconv.ParseFloat(seconds, 64); err == nil && duration > 0 {
+				pending.Duration = int(duration + 0.5)
+			}
+			pending.Artist, pending.Title = splitTitle(display)
+		case strings.HasPrefix(line, "#PLAYLIST:"):
+			p.Title = strings.TrimSpace(strings.TrimPrefix(line, "#PLAYLIST:"))
+		case strings.HasPrefix(line, "#"):
+		default:
+			pending.Location = line
+			pending.Line = lineNumber
+			p.Entries = append(p.Entries, pending)
+			pending = Entry{}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, ErrMalformed
+		}
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func encodeM3U8(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "#EXTM3U")
+	if p.Title != "" {
+		fmt.Fprintf(bw, "#PLAYLIST:%s\n", singleLine(p.Title))
+	}
+
+	for _, entry := range p.Entries {
+		duration := entry.Duration
+		if duration == 0 {
+			duration = -1
+		}
+
+		fmt.Fprintf(bw, "#EXTINF:%d,%s\n", duration, singleLine(joinTitle(entry.Artist, entry.Title)))
+		fmt.Fprintln(bw, singleLine(entry.Location))
+	}
+
+	return bw.Flush()
+}
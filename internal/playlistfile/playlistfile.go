@@ -0,0 +1,141 @@
+package playlistfile
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported playlist format")
+	ErrMalformed         = errors.New("malformed playlist file")
+)
+
+const (
+	M3U8 = "m3u8"
+	PLS  = "pls"
+	XSPF = "xspf"
+	JSON = "json"
+)
+
+var Formats = []string{M3U8, PLS, XSPF, JSON}
+
+// Entry is one track of a playlist file. Duration is in seconds and is zero
+// when unknown. Line is the line the entry was read from for the line based
+// formats and zero otherwise.
+type Entry struct {
+	Location    string
+	Title       string
+	Artist      string
+	Duration    int
+	ContentHash string
+	Line        int
+}
+
+type Playlist struct {
+	Title   string
+	Entries []Entry
+}
+
+// ContentType returns the media type a file of the given format is served as.
+func ContentType(format string) string {
+	switch format {
+	case M3U8:
+		return "application/vnd.apple.mpegurl"
+	case PLS:
+		return "audio/x-scpls"
+	case XSPF:
+		return "application/xspf+xml"
+	case JSON:
+		return "application/json"
+	}
+	return "application/octet-stream"
+}
+
+// FormatFromContentType maps a request media type to a format, returning an
+// empty string for generic or unknown types.
+func FormatFromContentType(contentType string) string {
+	mediaType, _, _ := strings.Cut(strings.ToLower(contentType), ";")
+
+	switch strings.TrimSpace(mediaType) {
+	case "application/vnd.apple.mpegurl", "application/x-mpegurl", "audio/mpegurl", "audio/x-mpegurl":
+		return M3U8
+	case "audio/x-scpls", "audio/scpls":
+		return PLS
+	case "application/xspf+xml":
+		return XSPF
+	case "application/json":
+		return JSON
+	}
+	return ""
+}
+
+// Detect guesses the format of a playlist file from its first bytes. Files
+// that look like nothing else are treated as a plain list of locations.
+func Detect(data []byte) string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.TrimSpace(data)
+
+	switch {
+	case bytes.HasPrefix(data, []byte("[")) && bytes.HasPrefix(bytes.ToLower(data), []byte("[playlist]")):
+		return PLS
+	case bytes.HasPrefix(data, []byte("<")):
+		return XSPF
+	case bytes.HasPrefix(data, []byte("{")):
+		return JSON
+	}
+	return M3U8
+}
+
+func Decode(r io.Reader, format string) (*Playlist, error) {
+	switch format {
+	case M3U8:
+		return decodeM3U8(r)
+	case PLS:
+		return decodePLS(r)
+	case XSPF:
+		return decodeXSPF(r)
+	case JSON:
+		return decodeJSON(r)
+	}
+	return nil, ErrUnsupportedFormat
+}
+
+func Encode(w io.Writer, format string, p *Playlist) error {
+	switch format {
+	case M3U8:
+		return encodeM3U8(w, p)
+	case PLS:
+		return encodePLS(w, p)
+	case XSPF:
+		return encodeXSPF(w, p)
+	case JSON:
+		return encodeJSON(w, p)
+	}
+	return ErrUnsupportedFormat
+}
+
+// splitTitle splits the "Artist - Title" display text used by M3U and PLS.
+func splitTitle(s string) (artist string, title string) {
+	s = strings.TrimSpace(s)
+
+	artist, title, ok := strings.Cut(s, " - ")
+	if !ok {
+		return "", s
+	}
+	return strings.TrimSpace(artist), strings.TrimSpace(title)
+}
+
+func joinTitle(artist string, title string) string {
+	if artist == "" {
+		return title
+	}
+	return artist + " - " + title
+}
+
+// singleLine keeps values written into line based formats from starting a
+// new entry.
+func singleLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
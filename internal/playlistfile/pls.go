@@ -0,0 +1,117 @@
+package playlistfile
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func decodePLS(r io.Reader) (*Playlist, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	entries := make(map[int]*Entry)
+	lineNumber := 0
+	sawHeader := false
+
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if lineNumber == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+
+		if line == "" || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			sawHeader = sawHeader || strings.EqualFold(line, "[playlist]")
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, ErrMalformed
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+
+		// Keys are File<n>, Title<n> and Length<n>.
+		name := strings.TrimRight(key, "0123456789")
+		index, err := strconv.Atoi(key[len(name):])
+		if err != nil || index < 1 {
+			continue
+		}
+
+		entry, ok := entries[index]
+		if !ok {
+			entry = &Entry{}
+			entries[index] = entry
+		}
+
+		switch name {
+		case "file":
+			entry.Location = value
+			entry.Line = lineNumber
+		case "title":
+			entry.Artist, entry.Title = splitTitle(value)
+		case "length":
+			if duration, err := strconv.Atoi(value); err == nil && duration > 0 {
+				entry.Duration = duration
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, ErrMalformed
+		}
+		return nil, err
+	}
+
+	if !sawHeader {
+		return nil, ErrMalformed
+	}
+
+	indexes := make([]int, 0, len(entries))
+	for index, entry := range entries {
+		if entry.Location != "" {
+			indexes = append(indexes, index)
+		}
+	}
+	sort.Ints(indexes)
+
+	p := &Playlist{Entries: make([]Entry, 0, len(indexes))}
+	for _, index := range indexes {
+		p.Entries = append(p.Entries, *entries[index])
+	}
+
+	return p, nil
+}
+
+func encodePLS(w io.Writer, p *Playlist) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintln(bw, "[playlist]")
+
+	for i, entry := range p.Entries {
+		n := i + 1
+		duration := entry.Duration
+		if duration == 0 {
+			duration = -1
+		}
+
+		fmt.Fprintf(bw, "File%d=%s\n", n, singleLine(entry.Location))
+		fmt.Fprintf(bw, "Title%d=%s\n", n, singleLine(joinTitle(entry.Artist, entry.Title)))
+		fmt.Fprintf(bw, "Length%d=%d\n", n, duration)
+	}
+
+	fmt.Fprintf(bw, "NumberOfEntries=%d\n", len(p.Entries))
+	fmt.Fprintln(bw, "Version=2")
+
+	return bw.Flush()
+}
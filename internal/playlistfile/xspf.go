@@ -0,0 +1,103 @@
+package playlistfile
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+const xspfNamespace = "http://xspf.org/ns/0/"
+
+// hashURN identifies a track by the SHA-256 of its audio in the XSPF
+// identifier element.
+const hashURN = "urn:sha256:"
+
+type xspfPlaylist struct {
+	XMLName xml.Name    `xml:"playlist"`
+	Version string      `xml:"version,attr"`
+	XMLNS   string      `xml:"xmlns,attr"`
+	Title   string      `xml:"title,omitempty"`
+	Tracks  []xspfTrack `xml:"trackList>track"`
+}
+
+type xspfTrack struct {
+	Locations   []string `xml:"location"`
+	Identifiers []string `xml:"identifier"`
+	Title       string   `xml:"title,omitempty"`
+	Creator     string   `xml:"creator,omitempty"`
+	Duration    int64    `xml:"duration,omitempty"`
+}
+
+func decodeXSPF(r io.Reader) (*Playlist, error) {
+	var doc xspfPlaylist
+
+	err := xml.NewDecoder(r).Decode(&doc)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+
+	p := &Playlist{Title: strings.TrimSpace(doc.Title), Entries: make([]Entry, 0, len(doc.Tracks))}
+
+	for _, track := range doc.Tracks {
+		entry := Entry{
+			Title:  strings.TrimSpace(track.Title),
+			Artist: strings.TrimSpace(track.Creator),
+		}
+
+		if len(track.Locations) > 0 {
+			entry.Location = strings.TrimSpace(track.Locations[0])
+		}
+		if track.Duration > 0 {
+			entry.Duration = int((track.Duration + 500) / 1000)
+		}
+		for _, identifier := range track.Identifiers {
+			identifier = strings.TrimSpace(identifier)
+			if strings.HasPrefix(identifier, hashURN) {
+				entry.ContentHash = strings.TrimPrefix(identifier, hashURN)
+			}
+		}
+
+		p.Entries = append(p.Entries, entry)
+	}
+
+	return p, nil
+}
+
+func encodeXSPF(w io.Writer, p *Playlist) error {
+	doc := xspfPlaylist{
+		Version: "1",
+		XMLNS:   xspfNamespace,
+		Title:   p.Title,
+		Tracks:  make([]xspfTrack, 0, len(p.Entries)),
+	}
+
+	for _, entry := range p.Entries {
+		track := xspfTrack{
+			Locations: []string{entry.Location},
+			Title:     entry.Title,
+			Creator:   entry.Artist,
+			Duration:  int64(entry.Duration) * 1000,
+		}
+		if entry.ContentHash != "" {
+			track.Identifiers = []string{hashURN + entry.ContentHash}
+		}
+
+		doc.Tracks = append(doc.Tracks, track)
+	}
+
+	_, err := io.WriteString(w, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+
+	err = encoder.Encode(doc)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(w, "\n")
+	return err
+}
@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type Local struct {
+	root    string
+	baseURL string
+}
+
+func NewLocal(root string, baseURL string) (*Local, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(root, 0o755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Local{
+		root:    root,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}, nil
+}
+
+// FileSystem serves the objects under the given key prefixes, for example
+// "thumbnails/", over HTTP. Directories are never listed and any other key is
+// reported as not existing.
+func (l *Local) FileSystem(prefixes ...string) http.FileSystem {
+	return &localFS{dir: http.Dir(l.root), prefixes: prefixes}
+}
+
+type localFS struct {
+	dir      http.Dir
+	prefixes []string
+}
+
+func (f *localFS) Open(name string) (http.File, error) {
+	key := strings.TrimPrefix(name, "/")
+
+	if !validKey(key) || !f.allowed(key) {
+		return nil, fs.ErrNotExist
+	}
+
+	file, err := f.dir.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		file.Close()
+		return nil, fs.ErrNotExist
+	}
+
+	return file, nil
+}
+
+func (f *localFS) allowed(key string) bool {
+	for _, prefix := range f.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (l *Local) path(key string) (string, error) {
+	if !validKey(key) {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(l.root, filepath.FromSlash(key)), nil
+}
+
+func (l *Local) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	dst, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(dst), 0o755)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	n, err := io.Copy(tmp, body)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if size >= 0 && n != size {
+		tmp.Close()
+		return fmt.Errorf("storage: wrote %d bytes for %q, expected %d", n, key, size)
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), dst)
+}
+
+func (l *Local) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	src, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(src)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	return file, nil
+}
+
+func (l *Local) Delete(ctx context.Context, key string) error {
+	src, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(src)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return ErrNotFound
+		default:
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (l *Local) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	src, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(src)
+	if err != nil {
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			return nil, ErrNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	if info.IsDir() {
+		return nil, ErrNotFound
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(key))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	return &ObjectInfo{
+		Key:         key,
+		Size:        info.Size(),
+		ContentType: contentType,
+		ETag:        fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size()),
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+func (l *Local) URL(key string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return l.baseURL + "/" + strings.Join(parts, "/")
+}
@@ -0,0 +1,212 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	aws_config "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+type S3 struct {
+	client  *s3.Client
+	bucket  string
+	baseURL string
+}
+
+func NewS3(ctx context.Context, bucket string, region string, baseURL string) (*S3, error) {
+	var opts []func(*aws_config.LoadOptions) error
+	if region != "" {
+		opts = append(opts, aws_config.WithRegion(region))
+	}
+
+	cfg, err := aws_config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://%s.s3.%s.amazonaws.com", bucket, cfg.Region)
+	}
+
+	return &S3{
+		client:  s3.NewFromConfig(cfg),
+		bucket:  bucket,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+	}, nil
+}
+
+func (s *S3) Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+		Body:   body,
+	}
+	if size >= 0 {
+		input.ContentLength = aws.Int64(size)
+	}
+	if contentType != "" {
+		input.ContentType = aws.String(contentType)
+	}
+
+	_, err := s.client.PutObject(ctx, input)
+	return err
+}
+
+func (s *S3) Get(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	info, err := s.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return &s3Object{
+		ctx:  ctx,
+		s3:   s,
+		key:  key,
+		size: info.Size,
+	}, nil
+}
+
+func (s *S3) Delete(ctx context.Context, key string) error {
+	if !validKey(key) {
+		return ErrInvalidKey
+	}
+
+	_, err := s.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	return s.translate(err)
+}
+
+func (s *S3) Stat(ctx context.Context, key string) (*ObjectInfo, error) {
+	if !validKey(key) {
+		return nil, ErrInvalidKey
+	}
+
+	output, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, s.translate(err)
+	}
+
+	info := &ObjectInfo{
+		Key:         key,
+		Size:        aws.ToInt64(output.ContentLength),
+		ContentType: aws.ToString(output.ContentType),
+		ETag:        aws.ToString(output.ETag),
+		ModTime:     aws.ToTime(output.LastModified),
+	}
+
+	return info, nil
+}
+
+func (s *S3) URL(key string) string {
+	parts := strings.Split(key, "/")
+	for i := range parts {
+		parts[i] = url.PathEscape(parts[i])
+	}
+	return s.baseURL + "/" + strings.Join(parts, "/")
+}
+
+func (s *S3) translate(err error) error {
+	var (
+		noSuchKey *types.NoSuchKey
+		notFound  *types.NotFound
+	)
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &noSuchKey), errors.As(err, &notFound):
+		return ErrNotFound
+	default:
+		return err
+	}
+}
+
+// s3Object fetches an object lazily with ranged GETs so callers can seek
+// without downloading the bytes they skip.
+type s3Object struct {
+	ctx    context.Context
+	s3     *S3
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (o *s3Object) Read(p []byte) (int, error) {
+	if o.offset >= o.size {
+		return 0, io.EOF
+	}
+
+	if o.body == nil {
+		output, err := o.s3.client.GetObject(o.ctx, &s3.GetObjectInput{
+			Bucket: aws.String(o.s3.bucket),
+			Key:    aws.String(o.key),
+			Range:  aws.String(fmt.Sprintf("bytes=%d-", o.offset)),
+		})
+		if err != nil {
+			return 0, o.s3.translate(err)
+		}
+		o.body = output.Body
+	}
+
+	n, err := o.body.Read(p)
+	o.offset += int64(n)
+	return n, err
+}
+
+func (o *s3Object) Seek(offset int64, whence int) (int64, error) {
+	var next int64
+
+	switch whence {
+	case io.SeekStart:
+		next = offset
+	case io.SeekCurrent:
+		next = o.offset + offset
+	case io.SeekEnd:
+		next = o.size + offset
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+
+	if next < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+
+	if next != o.offset && o.body != nil {
+		o.body.Close()
+		o.body = nil
+	}
+	o.offset = next
+
+	return next, nil
+}
+
+func (o *s3Object) Close() error {
+	if o.body == nil {
+		return nil
+	}
+	err := o.body.Close()
+	o.body = nil
+	return err
+}
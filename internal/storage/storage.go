@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+type ObjectInfo struct {
+	Key         string
+	Size        int64
+	ContentType string
+	ETag        string
+	ModTime     time.Time
+}
+
+// Store is implemented by every media backend. Keys are slash separated
+// paths relative to the root of the backend.
+type Store interface {
+	Put(ctx context.Context, key string, body io.Reader, size int64, contentType string) error
+	Get(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	Delete(ctx context.Context, key string) error
+	Stat(ctx context.Context, key string) (*ObjectInfo, error)
+	URL(key string) string
+}
+
+// KeyFromURL reverses Store.URL, reporting false for URLs that do not
+// point into the store.
+func KeyFromURL(store Store, rawURL string) (string, bool) {
+	base := store.URL("")
+	if base == "" || !strings.HasPrefix(rawURL, base) {
+		return "", false
+	}
+
+	key, err := url.PathUnescape(strings.TrimPrefix(rawURL, base))
+	if err != nil || !validKey(key) {
+		return "", false
+	}
+
+	return key, true
+}
+
+func validKey(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+
+	return true
+}
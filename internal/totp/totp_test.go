@@ -0,0 +1,96 @@
+package totp
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of the RFC 6238 test vectors, the ASCII string
+// "12345678901234567890", base32 encoded.
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// TestCodeRFC6238 checks Code against the SHA-1 vectors of RFC 6238
+// Appendix B. The RFC lists eight digit codes; with six digits the code is
+// their last six.
+func TestCodeRFC6238(t *testing.T) {
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{unix: 59, want: "287082"},          // 94287082
+		{unix: 1111111109, want: "081804"},  // 07081804
+		{unix: 1111111111, want: "050471"},  // 14050471
+		{unix: 1234567890, want: "005924"},  // 89005924
+		{unix: 2000000000, want: "279037"},  // 69279037
+		{unix: 20000000000, want: "353130"}, // 65353130
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, Counter(time.Unix(tt.unix, 0)))
+		if err != nil {
+			t.Fatalf("%d: %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("%d: code = %s; want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestCodeInvalidSecret(t *testing.T) {
+	for _, secret := range []string{"", "not base32!"} {
+		_, err := Code(secret, 1)
+		if !errors.Is(err, ErrInvalidSecret) {
+			t.Errorf("%q: err = %v; want %v", secret, err, ErrInvalidSecret)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	current := Counter(now)
+
+	code := func(counter int64) string {
+		c, err := Code(rfcSecret, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+
+	tests := []struct {
+		name        string
+		code        string
+		wantCounter int64
+		wantOK      bool
+	}{
+		{name: "current period", code: code(current), wantCounter: current, wantOK: true},
+		{name: "previous period", code: code(current - Skew), wantCounter: current - Skew, wantOK: true},
+		{name: "next period", code: code(current + Skew), wantCounter: current + Skew, wantOK: true},
+		{name: "beyond skew in the past", code: code(current - Skew - 1)},
+		{name: "beyond skew in the future", code: code(current + Skew + 1)},
+		{name: "too short", code: code(current)[1:]},
+		{name: "too long", code: code(current) + "0"},
+		{name: "eight digit code", code: "14050471"},
+		{name: "empty", code: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			counter, ok := Validate(rfcSecret, tt.code, now)
+			if ok != tt.wantOK {
+				t.Fatalf("ok = %t; want %t", ok, tt.wantOK)
+			}
+			if counter != tt.wantCounter {
+				t.Errorf("counter = %d; want %d", counter, tt.wantCounter)
+			}
+		})
+	}
+}
+
+func TestValidateInvalidSecret(t *testing.T) {
+	_, ok := Validate("", "123456", time.Now())
+	if ok {
+		t.Error("ok = true; want false")
+	}
+}
@@ -0,0 +1,4 @@
+ALTER TABLE songs DROP COLUMN IF EXISTS duration; 
+ALTER TABLE songs DROP COLUMN IF EXISTS year; 
+ALTER TABLE songs DROP COLUMN IF EXISTS track_number; 
+ALTER TABLE songs DROP COLUMN IF EXISTS album; 
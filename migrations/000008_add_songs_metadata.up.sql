@@ -0,0 +1,4 @@
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS album text NOT NULL DEFAULT ''; 
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS track_number integer NOT NULL DEFAULT 0; 
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS year integer NOT NULL DEFAULT 0; 
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS duration integer NOT NULL DEFAULT 0; 
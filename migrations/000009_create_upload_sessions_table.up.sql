@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS upload_sessions(
+	id text PRIMARY KEY, 
+	user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE, 
+	created_at timestamp(0) with time zone NOT NULL DEFAULT now(), 
+	expiry timestamp(0) with time zone NOT NULL, 
+	filename text NOT NULL, 
+	content_type text NOT NULL DEFAULT '', 
+	size bigint NOT NULL, 
+	upload_offset bigint NOT NULL DEFAULT 0, 
+	chunks integer NOT NULL DEFAULT 0, 
+	version integer NOT NULL DEFAULT 1
+); 
+
+CREATE INDEX IF NOT EXISTS upload_sessions_expiry_idx ON upload_sessions(expiry); 
@@ -0,0 +1,2 @@
+ALTER TABLE songs DROP COLUMN IF EXISTS media_object_id; 
+DROP TABLE IF EXISTS media_objects; 
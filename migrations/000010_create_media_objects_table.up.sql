@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS media_objects(
+	id bigserial PRIMARY KEY, 
+	created_at timestamp(0) with time zone NOT NULL DEFAULT now(), 
+	hash text UNIQUE NOT NULL, 
+	key text NOT NULL, 
+	size bigint NOT NULL, 
+	content_type text NOT NULL DEFAULT '', 
+	cover_key text NOT NULL DEFAULT '', 
+	ref_count integer NOT NULL DEFAULT 0
+); 
+
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS media_object_id bigint REFERENCES media_objects ON DELETE SET NULL; 
+
+CREATE INDEX IF NOT EXISTS songs_media_object_id_idx ON songs(media_object_id); 
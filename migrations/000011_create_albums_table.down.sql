@@ -0,0 +1,10 @@
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS album text NOT NULL DEFAULT ''; 
+
+UPDATE songs SET album = albums.title 
+	FROM albums 
+	WHERE albums.id = songs.album_id; 
+
+DROP INDEX IF EXISTS songs_album_id_idx; 
+ALTER TABLE songs DROP COLUMN IF EXISTS disc_number; 
+ALTER TABLE songs DROP COLUMN IF EXISTS album_id; 
+DROP TABLE IF EXISTS albums; 
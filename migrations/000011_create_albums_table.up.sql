@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS albums(
+	id bigserial PRIMARY KEY, 
+	created_at timestamp(0) with time zone NOT NULL DEFAULT now(), 
+	title text NOT NULL, 
+	artist text NOT NULL, 
+	year integer NOT NULL DEFAULT 0, 
+	thumbnail text NOT NULL DEFAULT '', 
+	version integer NOT NULL DEFAULT 1
+); 
+
+CREATE UNIQUE INDEX IF NOT EXISTS albums_title_artist_key ON albums (lower(title), lower(artist)); 
+CREATE INDEX IF NOT EXISTS albums_title_idx ON albums USING GIN (to_tsvector('simple', title)); 
+CREATE INDEX IF NOT EXISTS albums_artist_idx ON albums USING GIN (to_tsvector('simple', artist)); 
+
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS album_id bigint REFERENCES albums ON DELETE SET NULL; 
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS disc_number integer NOT NULL DEFAULT 0; 
+
+INSERT INTO albums (title, artist, year, thumbnail) 
+	SELECT DISTINCT ON (lower(album), lower(artist)) album, artist, year, thumbnail 
+	FROM songs 
+	WHERE album <> '' 
+	ORDER BY lower(album), lower(artist), id 
+ON CONFLICT DO NOTHING; 
+
+UPDATE songs SET album_id = albums.id 
+	FROM albums 
+	WHERE songs.album <> '' 
+	AND lower(albums.title) = lower(songs.album) 
+	AND lower(albums.artist) = lower(songs.artist); 
+
+ALTER TABLE songs DROP COLUMN IF EXISTS album; 
+
+CREATE INDEX IF NOT EXISTS songs_album_id_idx ON songs(album_id); 
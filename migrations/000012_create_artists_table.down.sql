@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS song_artists; 
+DROP TABLE IF EXISTS artists; 
@@ -0,0 +1,36 @@
+CREATE TABLE IF NOT EXISTS artists(
+	id bigserial PRIMARY KEY, 
+	created_at timestamp(0) with time zone NOT NULL DEFAULT now(), 
+	name text NOT NULL, 
+	normalized_name text NOT NULL UNIQUE, 
+	version integer NOT NULL DEFAULT 1
+); 
+
+CREATE INDEX IF NOT EXISTS artists_name_idx ON artists USING GIN (to_tsvector('simple', name)); 
+
+CREATE TABLE IF NOT EXISTS song_artists(
+	song_id bigint NOT NULL REFERENCES songs ON DELETE CASCADE, 
+	artist_id bigint NOT NULL REFERENCES artists ON DELETE CASCADE, 
+	role text NOT NULL DEFAULT 'primary' CHECK (role IN ('primary', 'featured', 'composer', 'remixer')), 
+	PRIMARY KEY (song_id, artist_id, role) 
+); 
+
+CREATE INDEX IF NOT EXISTS song_artists_artist_id_idx ON song_artists(artist_id); 
+
+INSERT INTO artists (name, normalized_name) 
+	SELECT DISTINCT ON (normalized_name) name, normalized_name 
+	FROM (
+		SELECT btrim(regexp_replace(artist, '\s+', ' ', 'g')) AS name, 
+		       regexp_replace(lower(btrim(regexp_replace(artist, '\s+', ' ', 'g'))), '^the ', '') AS normalized_name, 
+		       id 
+		FROM songs 
+		WHERE btrim(artist) <> '' 
+	) AS names 
+	ORDER BY normalized_name, id 
+ON CONFLICT DO NOTHING; 
+
+INSERT INTO song_artists (song_id, artist_id, role) 
+	SELECT songs.id, artists.id, 'primary' 
+	FROM songs 
+	INNER JOIN artists ON artists.normalized_name = regexp_replace(lower(btrim(regexp_replace(songs.artist, '\s+', ' ', 'g'))), '^the ', '') 
+ON CONFLICT DO NOTHING; 
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS songs_genres_idx; 
+
+ALTER TABLE songs DROP COLUMN IF EXISTS genres; 
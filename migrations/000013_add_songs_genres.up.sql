@@ -0,0 +1,3 @@
+ALTER TABLE songs ADD COLUMN IF NOT EXISTS genres text[] NOT NULL DEFAULT '{}'; 
+
+CREATE INDEX IF NOT EXISTS songs_genres_idx ON songs USING GIN (genres); 
@@ -0,0 +1,3 @@
+ALTER TABLE playlist_songs DROP CONSTRAINT IF EXISTS playlist_songs_position_key; 
+
+ALTER TABLE playlist_songs DROP COLUMN IF EXISTS position; 
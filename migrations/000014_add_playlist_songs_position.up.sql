@@ -0,0 +1,12 @@
+ALTER TABLE playlist_songs ADD COLUMN IF NOT EXISTS position integer; 
+
+UPDATE playlist_songs SET position = ranked.position 
+	FROM (
+		SELECT id, row_number() OVER (PARTITION BY playlist_id ORDER BY id) AS position 
+		FROM playlist_songs 
+	) AS ranked 
+	WHERE playlist_songs.id = ranked.id; 
+
+ALTER TABLE playlist_songs ALTER COLUMN position SET NOT NULL; 
+
+ALTER TABLE playlist_songs ADD CONSTRAINT playlist_songs_position_key UNIQUE (playlist_id, position) DEFERRABLE INITIALLY DEFERRED; 
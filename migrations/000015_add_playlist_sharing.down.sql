@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS playlist_members; 
+
+DROP INDEX IF EXISTS playlists_user_id_idx; 
+
+ALTER TABLE playlists DROP COLUMN IF EXISTS visibility; 
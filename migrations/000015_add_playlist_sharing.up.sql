@@ -0,0 +1,14 @@
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS visibility text NOT NULL DEFAULT 'private' 
+	CHECK (visibility IN ('private', 'unlisted', 'public')); 
+
+CREATE INDEX IF NOT EXISTS playlists_user_id_idx ON playlists(user_id); 
+
+CREATE TABLE IF NOT EXISTS playlist_members(
+	playlist_id bigint NOT NULL REFERENCES playlists ON DELETE CASCADE, 
+	user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE, 
+	role text NOT NULL CHECK (role IN ('viewer', 'editor')), 
+	created_at timestamp(0) with time zone NOT NULL DEFAULT now(), 
+	PRIMARY KEY (playlist_id, user_id) 
+); 
+
+CREATE INDEX IF NOT EXISTS playlist_members_user_id_idx ON playlist_members(user_id); 
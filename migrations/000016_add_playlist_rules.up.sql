@@ -0,0 +1 @@
+ALTER TABLE playlists ADD COLUMN IF NOT EXISTS rules jsonb; 
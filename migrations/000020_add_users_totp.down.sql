@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS recovery_codes; 
+
+ALTER TABLE tokens DROP COLUMN IF EXISTS attempts; 
+
+ALTER TABLE users DROP COLUMN IF EXISTS totp_last_counter; 
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled; 
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret; 
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret text; 
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false; 
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_last_counter bigint NOT NULL DEFAULT 0; 
+
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS attempts integer NOT NULL DEFAULT 0; 
+
+CREATE TABLE IF NOT EXISTS recovery_codes(
+	id bigserial PRIMARY KEY, 
+	user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE, 
+	hash bytea NOT NULL, 
+	used_at timestamp(0) with time zone 
+); 
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON recovery_codes(user_id); 
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS oidc_login_states(
+	state_hash bytea PRIMARY KEY, 
+	nonce text NOT NULL, 
+	code_verifier text NOT NULL, 
+	expiry timestamp(0) with time zone NOT NULL 
+); 
+
+CREATE TABLE IF NOT EXISTS user_identities(
+	id bigserial PRIMARY KEY, 
+	user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE, 
+	issuer text NOT NULL, 
+	subject text NOT NULL, 
+	email citext NOT NULL, 
+	created_at timestamp(0) with time zone NOT NULL DEFAULT now(), 
+	UNIQUE (issuer, subject) 
+); 
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON user_identities(user_id); 
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS users_roles; 
+DROP TABLE IF EXISTS roles_permissions; 
+DROP TABLE IF EXISTS roles; 
+
+DELETE FROM permissions WHERE code IN ('songs:create', 'songs:delete', 'songs:upload', 'albums:write', 'artists:write'); 
+
+DROP INDEX IF EXISTS permissions_code_idx; 
@@ -0,0 +1,64 @@
+CREATE UNIQUE INDEX IF NOT EXISTS permissions_code_idx ON permissions(code); 
+
+INSERT INTO permissions (code) VALUES 
+	('songs:read'), 
+	('songs:write'), 
+	('songs:create'), 
+	('songs:delete'), 
+	('songs:upload'), 
+	('albums:write'), 
+	('artists:write') 
+ON CONFLICT (code) DO NOTHING; 
+
+CREATE TABLE IF NOT EXISTS roles(
+	id bigserial PRIMARY KEY, 
+	name text NOT NULL UNIQUE 
+); 
+
+CREATE TABLE IF NOT EXISTS roles_permissions(
+	role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE, 
+	permission_id bigint NOT NULL REFERENCES permissions ON DELETE CASCADE, 
+	PRIMARY KEY(role_id, permission_id) 
+); 
+
+CREATE TABLE IF NOT EXISTS users_roles(
+	user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE, 
+	role_id bigint NOT NULL REFERENCES roles ON DELETE CASCADE, 
+	PRIMARY KEY(user_id, role_id) 
+); 
+
+INSERT INTO roles (name) VALUES 
+	('listener'), 
+	('uploader'), 
+	('curator'), 
+	('admin') 
+ON CONFLICT (name) DO NOTHING; 
+
+INSERT INTO roles_permissions 
+SELECT roles.id, permissions.id 
+FROM (VALUES 
+	('listener', 'songs:read'), 
+	('uploader', 'songs:read'), 
+	('uploader', 'songs:create'), 
+	('uploader', 'songs:upload'), 
+	('curator', 'songs:read'), 
+	('curator', 'songs:write'), 
+	('curator', 'songs:delete'), 
+	('curator', 'albums:write'), 
+	('curator', 'artists:write') 
+) AS grants(role, code) 
+INNER JOIN roles ON roles.name = grants.role 
+INNER JOIN permissions ON permissions.code = grants.code 
+ON CONFLICT DO NOTHING; 
+
+INSERT INTO roles_permissions 
+SELECT roles.id, permissions.id 
+FROM roles, permissions 
+WHERE roles.name = 'admin' 
+ON CONFLICT DO NOTHING; 
+
+INSERT INTO users_roles 
+SELECT users.id, roles.id 
+FROM users, roles 
+WHERE roles.name = 'listener' 
+ON CONFLICT DO NOTHING; 
@@ -0,0 +1,3 @@
+DELETE FROM permissions WHERE code = 'admin'; 
+
+ALTER TABLE users DROP COLUMN IF EXISTS suspended; 
@@ -0,0 +1,9 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended boolean NOT NULL DEFAULT false; 
+
+INSERT INTO permissions (code) VALUES ('admin') ON CONFLICT (code) DO NOTHING; 
+
+INSERT INTO roles_permissions 
+SELECT roles.id, permissions.id 
+FROM roles, permissions 
+WHERE roles.name = 'admin' AND permissions.code = 'admin' 
+ON CONFLICT DO NOTHING; 
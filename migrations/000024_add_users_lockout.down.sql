@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS locked_until; 
+ALTER TABLE users DROP COLUMN IF EXISTS failed_logins; 
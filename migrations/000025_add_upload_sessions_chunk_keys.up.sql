@@ -0,0 +1,6 @@
+ALTER TABLE upload_sessions ADD COLUMN IF NOT EXISTS chunk_keys text[] NOT NULL DEFAULT '{}'; 
+
+UPDATE upload_sessions SET chunk_keys = ARRAY( 
+	SELECT format('uploads/%s/%s', id, lpad(n::text, 6, '0')) FROM generate_series(0, chunks - 1) AS n 
+	ORDER BY n 
+); 
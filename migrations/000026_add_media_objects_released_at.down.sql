@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS media_objects_released_at_idx; 
+ALTER TABLE media_objects DROP COLUMN IF EXISTS released_at; 
@@ -0,0 +1,3 @@
+ALTER TABLE media_objects ADD COLUMN IF NOT EXISTS released_at timestamp(0) with time zone; 
+
+CREATE INDEX IF NOT EXISTS media_objects_released_at_idx ON media_objects(released_at) WHERE ref_count = 0; 